@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+const posterFileName = "poster.jpg"
+const thumbnailFileName = "thumbnail.jpg"
+const thumbnailWidth = 320
+
+// GenerateArtwork writes poster.jpg and thumbnail.jpg into outputDir. The poster
+// is taken at the posterTimestamp setting, or at a scene change near the start
+// of the video when that is empty.
+func GenerateArtwork(ctx context.Context, inputFile, outputDir string, runtime int) error {
+	poster := filepath.Join(outputDir, posterFileName)
+	thumbnail := filepath.Join(outputDir, thumbnailFileName)
+
+	if AppConfig.PosterTimestamp != "" {
+		if err := runFFmpeg(ctx, "-ss", AppConfig.PosterTimestamp, "-i", inputFile, "-frames:v", "1", "-q:v", "2", poster); err != nil {
+			return err
+		}
+	} else {
+		// Skip the first tenth of the video to avoid logos and black frames
+		start := strconv.Itoa(runtime / 10)
+		err := runFFmpeg(ctx, "-ss", start, "-t", "300", "-i", inputFile,
+			"-vf", "select='gt(scene,0.3)'", "-frames:v", "1", "-fps_mode", "vfr", "-q:v", "2", poster)
+		if err != nil || !fileExists(poster) {
+			// No scene change found, let ffmpeg pick a representative frame
+			err = runFFmpeg(ctx, "-ss", start, "-i", inputFile, "-vf", "thumbnail", "-frames:v", "1", "-q:v", "2", poster)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !fileExists(poster) {
+		return fmt.Errorf("ffmpeg did not produce a poster frame")
+	}
+
+	return runFFmpeg(ctx, "-i", poster, "-vf", fmt.Sprintf("scale=%d:-2", thumbnailWidth), "-q:v", "4", thumbnail)
+}
+
+func runFFmpeg(ctx context.Context, args ...string) error {
+	args = append([]string{"-hide_banner", "-loglevel", "error", "-y"}, args...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	killProcessGroupOnCancel(cmd)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("ffmpeg command failed: %w\nCommand: %s\nOutput:\n%s", err, cmd.String(), string(output))
+	}
+	return nil
+}
+
+func fileExists(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.Size() > 0
+}
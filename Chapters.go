@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const chaptersFileName = "chapters.json"
+const chaptersVTTFileName = "chapters.vtt"
+
+var (
+	introPattern   = regexp.MustCompile(`(?i)\b(intro|opening|op|recap|previously)\b`)
+	creditsPattern = regexp.MustCompile(`(?i)\b(credits|outro|ending|ed|preview|next episode)\b`)
+)
+
+// Chapter is a single entry of chapters.json. Kind is "intro", "credits" or
+// empty, so the player can offer to skip the first two.
+type Chapter struct {
+	Start float64 `json:"start"` // seconds
+	End   float64 `json:"end"`   // seconds
+	Title string  `json:"title"`
+	Kind  string  `json:"kind,omitempty"`
+}
+
+// GenerateChapters writes chapters.json and chapters.vtt into outputDir. It
+// returns false when the source has no chapters.
+func GenerateChapters(inputFile, outputDir string) (bool, error) {
+	probe, err := ProbeMedia(inputFile)
+	if err != nil {
+		return false, err
+	}
+	chapters := chaptersFromProbe(probe.Chapters)
+	if len(chapters) == 0 {
+		return false, nil
+	}
+
+	data, err := json.MarshalIndent(chapters, "", "  ")
+	if err != nil {
+		return false, fmt.Errorf("error encoding chapters: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, chaptersFileName), data, 0644); err != nil {
+		return false, fmt.Errorf("error writing chapters: %w", err)
+	}
+
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n\n")
+	for i, chapter := range chapters {
+		fmt.Fprintf(&vtt, "%d\n%s --> %s\n%s\n\n", i+1, vttTimestamp(chapter.Start), vttTimestamp(chapter.End), chapter.Title)
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, chaptersVTTFileName), []byte(vtt.String()), 0644); err != nil {
+		return false, fmt.Errorf("error writing chapters: %w", err)
+	}
+	return true, nil
+}
+
+func chaptersFromProbe(probed []ProbeChapter) []Chapter {
+	var chapters []Chapter
+	for i, c := range probed {
+		start, errStart := strconv.ParseFloat(c.StartTime, 64)
+		end, errEnd := strconv.ParseFloat(c.EndTime, 64)
+		if errStart != nil || errEnd != nil || end <= start {
+			continue
+		}
+		title := strings.TrimSpace(c.Tags["title"])
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", i+1)
+		}
+		chapter := Chapter{Start: start, End: end, Title: title}
+		if introPattern.MatchString(title) {
+			chapter.Kind = "intro"
+		} else if creditsPattern.MatchString(title) {
+			chapter.Kind = "credits"
+		}
+		chapters = append(chapters, chapter)
+	}
+	return chapters
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// FileChecksums are the hex SHA-256 sums of a whole file and of each upload chunk of it
+type FileChecksums struct {
+	File   string
+	Chunks []string
+}
+
+// ChecksumError is returned when the server echoes a checksum that doesn't
+// match what was sent, meaning the data was corrupted on the way
+type ChecksumError struct {
+	Field    string
+	Sent     string
+	Received string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("server reported %s %s, expected %s", e.Field, e.Received, e.Sent)
+}
+
+// ChecksumFile hashes the file and every chunkSize piece of it in one pass
+func ChecksumFile(file io.ReaderAt, size, chunkSize int64) (FileChecksums, error) {
+	var sums FileChecksums
+	fileHash := sha256.New()
+	for offset := int64(0); offset < size; offset += chunkSize {
+		chunkHash := sha256.New()
+		section := io.NewSectionReader(file, offset, min(chunkSize, size-offset))
+		if _, err := io.Copy(io.MultiWriter(fileHash, chunkHash), section); err != nil {
+			return sums, err
+		}
+		sums.Chunks = append(sums.Chunks, hexSum(chunkHash))
+	}
+	sums.File = hexSum(fileHash)
+	return sums, nil
+}
+
+func hexSum(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// verifyEchoedChecksums compares the checksums the server sent back, as
+// X-Chunk-SHA256/X-File-SHA256 headers or chunkSha256/fileSha256 in a JSON
+// body, with the ones sent. Servers that don't echo anything pass.
+func verifyEchoedChecksums(header http.Header, body []byte, chunkSum, fileSum string) error {
+	var echoed struct {
+		ChunkSha256 string `json:"chunkSha256"`
+		FileSha256  string `json:"fileSha256"`
+	}
+	// Not every server answers with JSON
+	json.Unmarshal(body, &echoed)
+
+	checks := []struct{ field, sent, received string }{
+		{"chunk checksum", chunkSum, header.Get("X-Chunk-SHA256")},
+		{"chunk checksum", chunkSum, echoed.ChunkSha256},
+		{"file checksum", fileSum, header.Get("X-File-SHA256")},
+		{"file checksum", fileSum, echoed.FileSha256},
+	}
+	for _, c := range checks {
+		if c.received != "" && c.sent != "" && !strings.EqualFold(c.received, c.sent) {
+			return &ChecksumError{Field: c.field, Sent: c.sent, Received: c.received}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const configFileName = "config.json"
+const envPrefix = "FCLI_"
+
+// EncodeProfile is a named set of ffmpeg video settings
+type EncodeProfile struct {
+	Encoder      string `json:"encoder"` // "auto" picks a hardware encoder when useHardwareAccel is on
+	CRF          int    `json:"crf"`
+	Preset       string `json:"preset"`
+	AudioBitrate string `json:"audioBitrate"`
+}
+
+// Config is read from config.json in ConfigDir. Every value can be overridden
+// by an FCLI_* environment variable and then by a command line flag, e.g.
+// chunkSize, FCLI_CHUNK_SIZE and --chunk-size.
+type Config struct {
+	UseMulti         bool                     `json:"useMulti"`
+	UseHardwareAccel bool                     `json:"useHardwareAccel"`
+	UseTrickplay     bool                     `json:"useTrickplay"`
+	Concurrency      int                      `json:"concurrency"` // parallel transcodes with useMulti, 0 uses the number of CPUs
+	EncodeProfile    string                   `json:"encodeProfile"`
+	EncodeProfiles   map[string]EncodeProfile `json:"encodeProfiles"`
+	FallbackProfile  string                   `json:"fallbackProfile"` // encode profile to retry failed transcodes with, empty to not retry
+	Package          string                   `json:"package"`         // zip, tar, tar.zst or directory
+	ZipCompression   string                   `json:"zipCompression"`  // store or deflate
+	ChunkSize        ByteSize                 `json:"chunkSize"`
+	LoginTimeout     Duration                 `json:"loginTimeout"`  // 0 for no timeout
+	UploadTimeout    Duration                 `json:"uploadTimeout"` // per chunk, not counting time held back by limit, 0 for no timeout
+	Limit            Rate                     `json:"limit"`         // upload bandwidth shared by all files, 0 for no limit
+	UploadWindow     string                   `json:"uploadWindow"`  // e.g. 22:00-06:00, uploads wait outside of it
+	OnDuplicate      string                   `json:"onDuplicate"`   // ask, skip or upload when something was uploaded before
+	TempDir          string                   `json:"tempDir"`       // where HLS output is built before zipping, empty for the media folder
+	PosterTimestamp  string                   `json:"posterTimestamp"`
+	DefaultDirectory string                   `json:"defaultDirectory"`
+	DefaultMediaType string                   `json:"defaultMediaType"`
+}
+
+var AppConfig = DefaultConfig()
+
+// configOverrides are the --key=value flags, applied after the environment
+var configOverrides [][2]string
+
+func DefaultConfig() Config {
+	return Config{
+		EncodeProfile: "default",
+		EncodeProfiles: map[string]EncodeProfile{
+			"default":  {Encoder: "auto", CRF: 23},
+			"software": {Encoder: "libx264", CRF: 23, Preset: "medium"},
+			"fast":     {Encoder: "libx264", CRF: 26, Preset: "veryfast"},
+		},
+		ChunkSize:      15 * 1024 * 1024,
+		OnDuplicate:    duplicateAsk,
+		Package:        "zip",
+		ZipCompression: "store",
+		LoginTimeout:   Duration(time.Minute * 60),
+		UploadTimeout:  Duration(time.Minute * 30),
+	}
+}
+
+// ActiveEncodeProfile returns the selected encode profile, falling back to the default one
+func (c Config) ActiveEncodeProfile() EncodeProfile {
+	if p, ok := c.EncodeProfiles[c.EncodeProfile]; ok {
+		return p
+	}
+	log.Printf("Unknown encode profile %s, using default", c.EncodeProfile)
+	return DefaultConfig().EncodeProfiles["default"]
+}
+
+func (c Config) Workers() int {
+	if c.Concurrency > 0 {
+		return c.Concurrency
+	}
+	return runtime.NumCPU()
+}
+
+func configPath() string {
+	return filepath.Join(ConfigDir(), configFileName)
+}
+
+// ConfigDir is where the config, profiles and sessions are kept, e.g. ~/.config/farnsworth-cli
+func ConfigDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		log.Fatal(err)
+	}
+	dir = filepath.Join(dir, "farnsworth-cli")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Fatal(err)
+	}
+	return dir
+}
+
+// LoadConfig reads the config file and applies environment and flag overrides.
+// It returns an os.IsNotExist error when there is no config file yet.
+func LoadConfig() (Config, error) {
+	config, err := loadConfigFile()
+	if err != nil && !os.IsNotExist(err) {
+		return config, err
+	}
+	fileErr := err
+
+	config, err = ApplyConfigOverrides(config)
+	if err != nil {
+		return config, err
+	}
+	return config, fileErr
+}
+
+// ApplyConfigOverrides applies the FCLI_* environment variables and then the
+// flags to config. Overrides are only for the current run, the result must
+// not be saved.
+func ApplyConfigOverrides(config Config) (Config, error) {
+	for _, key := range configKeys() {
+		if value, ok := os.LookupEnv(envPrefix + envName(key)); ok {
+			if err := config.Set(key, value); err != nil {
+				return config, fmt.Errorf("%s%s: %w", envPrefix, envName(key), err)
+			}
+		}
+	}
+	for _, kv := range configOverrides {
+		if err := config.Set(kv[0], kv[1]); err != nil {
+			return config, fmt.Errorf("--%s: %w", flagName(kv[0]), err)
+		}
+	}
+	return config, nil
+}
+
+// loadConfigFile reads only the file, migrating the config.json older versions
+// kept next to the executable
+func loadConfigFile() (Config, error) {
+	config := DefaultConfig()
+	data, err := os.ReadFile(configPath())
+	if os.IsNotExist(err) {
+		legacy, legacyErr := loadLegacyConfig()
+		if legacyErr != nil {
+			return config, err
+		}
+		config.UseMulti = legacy["useMulti"]
+		config.UseHardwareAccel = legacy["useHardwareAccel"]
+		config.UseTrickplay = legacy["useTrickplay"]
+		fmt.Printf("Moved configuration to %s\n", configPath())
+		return config, SaveConfig(config)
+	}
+	if err != nil {
+		return config, err
+	}
+
+	// Keep the built in encode profiles unless the file replaces them
+	profiles := config.EncodeProfiles
+	config.EncodeProfiles = nil
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("error parsing %s: %w", configPath(), err)
+	}
+	for name, p := range config.EncodeProfiles {
+		profiles[name] = p
+	}
+	config.EncodeProfiles = profiles
+	return config, nil
+}
+
+func loadLegacyConfig() (map[string]bool, error) {
+	execPath, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(execPath), configFileName))
+	if err != nil {
+		return nil, err
+	}
+	config := map[string]bool{}
+	err = json.Unmarshal(data, &config)
+	return config, err
+}
+
+func SaveConfig(config Config) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(configPath(), data, 0644)
+}
+
+// RegisterConfigFlags adds a --flag for every config key
+func RegisterConfigFlags() {
+	defaults := DefaultConfig()
+	for _, key := range configKeys() {
+		key := key
+		value, _ := defaults.Get(key)
+		usage := fmt.Sprintf("override the %s setting (default %s)", key, value)
+		record := func(v string) error {
+			configOverrides = append(configOverrides, [2]string{key, v})
+			return nil
+		}
+		if field, _ := configField(&defaults, key); field.Kind() == reflect.Bool {
+			flag.BoolFunc(flagName(key), usage, record)
+		} else {
+			flag.Func(flagName(key), usage, record)
+		}
+	}
+}
+
+// HandleConfigCommand implements fcli config show/set
+func HandleConfigCommand(args []string) {
+	if len(args) == 0 || args[0] == "show" {
+		config, err := LoadConfig()
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Println("Error loading configuration:", err)
+			return
+		}
+		data, _ := json.MarshalIndent(config, "", "  ")
+		fmt.Printf("# %s (including FCLI_* and flag overrides)\n%s\n", configPath(), data)
+		return
+	}
+
+	switch args[0] {
+	case "set":
+		if len(args) != 3 {
+			fmt.Println("Usage: fcli config set <key> <value>")
+			fmt.Println("Keys:", strings.Join(configKeys(), ", "))
+			return
+		}
+		// Only the file is changed, overrides from the environment are not saved
+		config, err := loadConfigFile()
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Println("Error loading configuration:", err)
+			return
+		}
+		if err := config.Set(args[1], args[2]); err != nil {
+			fmt.Println(err)
+			return
+		}
+		if err := SaveConfig(config); err != nil {
+			fmt.Println("Error saving configuration:", err)
+			return
+		}
+		value, _ := config.Get(args[1])
+		fmt.Printf("%s = %s\n", args[1], value)
+	default:
+		fmt.Printf("Unknown config command %s\n", args[0])
+		fmt.Print(PrintUsage())
+	}
+}
+
+// configKeys lists the settings that can be changed from the command line.
+// Encode profiles are maps and have to be edited in the file.
+func configKeys() []string {
+	var keys []string
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Type.Kind() == reflect.Map {
+			continue
+		}
+		keys = append(keys, jsonName(t.Field(i)))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func configField(c *Config, key string) (reflect.Value, bool) {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if jsonName(v.Type().Field(i)) == key && v.Field(i).Kind() != reflect.Map {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// Set parses value into the setting named by its json key
+func (c *Config) Set(key, value string) error {
+	field, ok := configField(c, key)
+	if !ok {
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	switch field.Interface().(type) {
+	case Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration for %s: %w", key, err)
+		}
+		if d < 0 {
+			return fmt.Errorf("invalid duration for %s: use 0 for no timeout", key)
+		}
+		field.Set(reflect.ValueOf(Duration(d)))
+	case Rate:
+		rate, err := ParseRate(value)
+		if err != nil {
+			return fmt.Errorf("invalid rate for %s: %w", key, err)
+		}
+		field.Set(reflect.ValueOf(rate))
+	case ByteSize:
+		size, err := ParseByteSize(value)
+		if err != nil {
+			return fmt.Errorf("invalid size for %s: %w", key, err)
+		}
+		field.Set(reflect.ValueOf(ByteSize(size)))
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+		field.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid number for %s: %w", key, err)
+		}
+		field.SetInt(int64(n))
+	case string:
+		if err := c.checkChoice(key, value); err != nil {
+			return err
+		}
+		field.SetString(value)
+	}
+	return nil
+}
+
+// checkChoice rejects values of settings that only take a few, so a typo
+// fails right away instead of halfway through a run
+func (c *Config) checkChoice(key, value string) error {
+	var choices []string
+	switch key {
+	case "onDuplicate":
+		choices = []string{duplicateAsk, duplicateSkip, duplicateUpload}
+	case "package":
+		choices = []string{packageDirectory}
+		for name := range packagers {
+			choices = append(choices, name)
+		}
+	case "zipCompression":
+		choices = []string{"store", "deflate"}
+	case "encodeProfile", "fallbackProfile":
+		if value == "" && key == "fallbackProfile" {
+			return nil
+		}
+		for name := range c.EncodeProfiles {
+			choices = append(choices, name)
+		}
+	case "uploadWindow":
+		_, err := ParseUploadWindows(value)
+		return err
+	default:
+		return nil
+	}
+	if slices.Contains(choices, value) {
+		return nil
+	}
+	sort.Strings(choices)
+	return fmt.Errorf("invalid value %q for %s, expected one of %s", value, key, strings.Join(choices, ", "))
+}
+
+func (c *Config) Get(key string) (string, bool) {
+	field, ok := configField(c, key)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprint(field.Interface()), true
+}
+
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	return name
+}
+
+// envName turns chunkSize into CHUNK_SIZE
+func envName(key string) string {
+	var b strings.Builder
+	for i, r := range key {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToUpper(b.String())
+}
+
+// flagName turns chunkSize into chunk-size
+func flagName(key string) string {
+	return strings.ToLower(strings.ReplaceAll(envName(key), "_", "-"))
+}
+
+// Duration is a time.Duration written as "30m" in the config file
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// ByteSize is a number of bytes written as "15MiB" in the config file
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+	{"GB", 1000 * 1000 * 1000}, {"MB", 1000 * 1000}, {"KB", 1000},
+	{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+}
+
+func (b ByteSize) String() string {
+	for _, unit := range byteUnits[:3] {
+		if int64(b) >= unit.size && int64(b)%unit.size == 0 {
+			return fmt.Sprintf("%d%s", int64(b)/unit.size, unit.suffix)
+		}
+	}
+	return strconv.FormatInt(int64(b), 10)
+}
+
+func (b ByteSize) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.String())
+}
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*b = ByteSize(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	size, err := ParseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = ByteSize(size)
+	return nil
+}
+
+// ParseByteSize accepts plain byte counts and sizes like 512KiB, 15MiB or 1.5GB
+func ParseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	for _, unit := range byteUnits {
+		if number, ok := strings.CutSuffix(s, unit.suffix); ok {
+			n, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid size %q", s)
+			}
+			return int64(n * float64(unit.size)), nil
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n, nil
+}
+
+// Rate is a number of bytes per second written as "5MiB/s" in the config file
+type Rate int64
+
+func (r Rate) String() string {
+	if r <= 0 {
+		return "0"
+	}
+	return ByteSize(r).String() + "/s"
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	rate, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+	*r = rate
+	return nil
+}
+
+// ParseRate accepts a size per second like 5MiB/s or 500KB, or 0 and off for no limit
+func ParseRate(s string) (Rate, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" || s == "off" {
+		return 0, nil
+	}
+	size, err := ParseByteSize(strings.TrimSuffix(s, "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return Rate(size), nil
+}
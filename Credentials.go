@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+const keyringService = "farnsworth-cli"
+
+// Secret references stored in credential files instead of the password itself
+const keyringRef = "keyring"
+const noSecretRef = "none"
+const encryptedRefPrefix = "enc:"
+
+// scrypt cost parameters for deriving the key from the master passphrase
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+const saltSize = 16
+
+// masterPassphrase is asked for once per run and reused for every encrypted secret
+var masterPassphrase []byte
+
+// StoreSecret saves secret in the OS keyring when one is available, otherwise it
+// is encrypted with a master passphrase. The returned reference is what gets
+// written to disk.
+func StoreSecret(r *bufio.Reader, account, secret string) (string, error) {
+	if keyringAvailable() {
+		err := keyringSet(account, secret)
+		if err == nil {
+			return keyringRef, nil
+		}
+		fmt.Printf("Could not use the system keyring (%v), falling back to an encrypted file\n", err)
+	}
+
+	passphrase, err := getMasterPassphrase(r, true)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := encryptSecret(passphrase, []byte(secret))
+	if err != nil {
+		return "", err
+	}
+	return encryptedRefPrefix + sealed, nil
+}
+
+// LoadSecret resolves a reference written by StoreSecret
+func LoadSecret(r *bufio.Reader, account, ref string) (string, error) {
+	if ref == keyringRef {
+		return keyringGet(account)
+	}
+	if sealed, ok := strings.CutPrefix(ref, encryptedRefPrefix); ok {
+		passphrase, err := getMasterPassphrase(r, false)
+		if err != nil {
+			return "", err
+		}
+		secret, err := decryptSecret(passphrase, sealed)
+		if err != nil {
+			// Most likely a typo, ask again next time
+			masterPassphrase = nil
+			return "", err
+		}
+		return string(secret), nil
+	}
+	return "", fmt.Errorf("unknown secret reference")
+}
+
+// DeleteSecret removes a secret from the keyring. Encrypted secrets live in the
+// credential file and go away with it.
+func DeleteSecret(account, ref string) error {
+	if ref != keyringRef {
+		return nil
+	}
+	return keyringDelete(account)
+}
+
+// MoveSecret moves a keyring secret to another account, keyring entries are
+// named after the user and url. Encrypted secrets don't depend on the account.
+func MoveSecret(oldAccount, newAccount, ref string) error {
+	if ref != keyringRef || oldAccount == newAccount {
+		return nil
+	}
+	secret, err := keyringGet(oldAccount)
+	if err != nil {
+		return err
+	}
+	if err := keyringSet(newAccount, secret); err != nil {
+		return err
+	}
+	return keyringDelete(oldAccount)
+}
+
+func getMasterPassphrase(r *bufio.Reader, confirm bool) ([]byte, error) {
+	if masterPassphrase != nil {
+		return masterPassphrase, nil
+	}
+	fmt.Println("Enter the master passphrase used to encrypt saved passwords:(passphrase will be hidden)")
+	passphrase, err := term.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("the master passphrase can not be empty")
+	}
+	if confirm {
+		fmt.Println("Confirm the master passphrase:")
+		again, err := term.ReadPassword(int(syscall.Stdin))
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(passphrase, again) {
+			return nil, fmt.Errorf("passphrases do not match")
+		}
+	}
+	masterPassphrase = passphrase
+	return passphrase, nil
+}
+
+func encryptSecret(passphrase, secret []byte) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	gcm, err := newSecretCipher(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	// salt | nonce | ciphertext
+	out := append(salt, nonce...)
+	out = gcm.Seal(out, nonce, secret, nil)
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+func decryptSecret(passphrase []byte, sealed string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("encrypted secret is corrupted")
+	}
+	if len(data) < saltSize {
+		return nil, fmt.Errorf("encrypted secret is corrupted")
+	}
+	gcm, err := newSecretCipher(passphrase, data[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	data = data[saltSize:]
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted secret is corrupted")
+	}
+	secret, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrong master passphrase or corrupted secret")
+	}
+	return secret, nil
+}
+
+func newSecretCipher(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// The keyring is reached through the tools shipped with the OS, secret-tool
+// (libsecret) on linux and security on macOS.
+func keyringAvailable() bool {
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd":
+		_, err := exec.LookPath("secret-tool")
+		return err == nil && os.Getenv("DBUS_SESSION_BUS_ADDRESS") != ""
+	case "darwin":
+		_, err := exec.LookPath("security")
+		return err == nil
+	}
+	return false
+}
+
+func keyringSet(account, secret string) error {
+	if runtime.GOOS == "darwin" {
+		return keychainSet(account, secret)
+	}
+	cmd := exec.Command("secret-tool", "store", "--label", "Farnsworth CLI "+account, "service", keyringService, "account", account)
+	cmd.Stdin = strings.NewReader(secret)
+	return runKeyringCommand(cmd)
+}
+
+// keychainSet stores the secret with security -i, which reads the command from
+// stdin, so the password never shows up in the process list
+func keychainSet(account, secret string) error {
+	if strings.ContainsAny(secret, "\r\n") || strings.ContainsAny(account, "\r\n") {
+		return fmt.Errorf("line breaks can not be stored in the keychain")
+	}
+	command := strings.Join([]string{"add-generic-password", "-U",
+		"-s", securityQuote(keyringService), "-a", securityQuote(account), "-w", securityQuote(secret)}, " ")
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(command + "\n")
+	if err := runKeyringCommand(cmd); err != nil {
+		return err
+	}
+	// security -i doesn't fail when one of its commands does, read it back to be sure
+	stored, err := keyringGet(account)
+	if err != nil {
+		return err
+	}
+	if stored != secret {
+		return errors.New("the keychain did not store the password")
+	}
+	return nil
+}
+
+// securityQuote quotes an argument for a security -i command line
+func securityQuote(arg string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+func keyringGet(account string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", account, "-w")
+	} else {
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", account)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("error reading from keyring: %w; %s", err, strings.TrimSpace(stderr.String()))
+	}
+	secret := strings.TrimSuffix(stdout.String(), "\n")
+	if secret == "" {
+		return "", errors.New("no password stored in the keyring for " + account)
+	}
+	return secret, nil
+}
+
+func keyringDelete(account string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", account)
+	} else {
+		cmd = exec.Command("secret-tool", "clear", "service", keyringService, "account", account)
+	}
+	return runKeyringCommand(cmd)
+}
+
+func runKeyringCommand(cmd *exec.Cmd) error {
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w; %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const fingerprintSamples = 16
+const fingerprintSampleSize = 64 * 1024
+
+// Values for the onDuplicate setting
+const (
+	duplicateAsk    = "ask"
+	duplicateSkip   = "skip"
+	duplicateUpload = "upload"
+)
+
+// keptDuplicates are fingerprints, or archive checksums, of duplicates the user chose to upload anyway,
+// so they aren't asked about twice
+var keptDuplicates = map[string]bool{}
+
+// serverLookupMissing is set once the server answered that it has no lookup endpoint
+var serverLookupMissing bool
+var serverLookupMu sync.Mutex
+
+// SourceFingerprint identifies a media file without reading all of it: the
+// SHA-256 of its size and evenly spaced samples of its content
+func SourceFingerprint(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, info.Size())
+	buf := make([]byte, fingerprintSampleSize)
+	step := max(info.Size()/fingerprintSamples, 1)
+	for offset := int64(0); offset < info.Size(); offset += step {
+		n, err := f.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		h.Write(buf[:n])
+	}
+	return hexSum(h), nil
+}
+
+// ArchiveChecksum is the SHA-256 of an archive about to be uploaded, the same
+// sum the history keeps of uploads. Directories and unreadable files give "".
+func ArchiveChecksum(name string) string {
+	f, err := os.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || info.IsDir() {
+		return ""
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		log.Printf("Error hashing %s: %v", name, err)
+		return ""
+	}
+	return hexSum(h)
+}
+
+// FindDuplicate looks for entry in the uploads in the history and, where the
+// server supports it, on the server. archiveSum is the SHA-256 of the archive
+// when there is one already, archives without a source have no fingerprint.
+// The reason describes the earlier upload.
+func FindDuplicate(entry MediaIndexEntry, archiveSum string) (string, bool) {
+	history, err := LoadHistory()
+	if err != nil {
+		log.Printf("Error reading history: %v", err)
+	}
+	for _, record := range history {
+		if record.Status != statusUploaded || record.URL != API_BASE_URL {
+			continue
+		}
+		uploaded := record.Time.Local().Format("2006-01-02")
+		if archiveSum != "" && record.SHA256 == archiveSum {
+			return fmt.Sprintf("the same archive was uploaded as %q on %s", record.Title, uploaded), true
+		}
+		if entry.Fingerprint != "" && record.Fingerprint == entry.Fingerprint {
+			return fmt.Sprintf("the same source file was uploaded as %q on %s", record.Title, uploaded), true
+		}
+		if sameMedia(entry, record) {
+			return fmt.Sprintf("%q was uploaded to %q on %s", record.Title, record.Directory, uploaded), true
+		}
+	}
+
+	reason, found, err := lookupOnServer(entry)
+	if err != nil {
+		log.Printf("Error checking the server for duplicates: %v", err)
+	}
+	return reason, found
+}
+
+// sameMedia compares episodes by series, season and episode number and
+// everything else by title and year. Directories only have to match when
+// both are known.
+func sameMedia(entry MediaIndexEntry, record HistoryEntry) bool {
+	if entry.Directory != "" && record.Directory != "" && !strings.EqualFold(entry.Directory, record.Directory) {
+		return false
+	}
+	if entry.Series != "" && entry.Episode > 0 {
+		return sameName(entry.Series, record.Series) && entry.Season == record.Season && entry.Episode == record.Episode
+	}
+	if entry.Year != 0 && record.Year != 0 && entry.Year != record.Year {
+		return false
+	}
+	return sameName(entry.Title, record.Title)
+}
+
+// lookupOnServer asks GET /media/lookup/ whether the instance already has the
+// media. Instances without the endpoint are only asked once.
+func lookupOnServer(entry MediaIndexEntry) (string, bool, error) {
+	serverLookupMu.Lock()
+	missing := serverLookupMissing
+	serverLookupMu.Unlock()
+	if missing || authClient == nil {
+		return "", false, nil
+	}
+
+	query := url.Values{}
+	query.Set("title", entry.Title)
+	query.Set("directory", entry.Directory)
+	query.Set("fingerprint", entry.Fingerprint)
+	if entry.Series != "" {
+		query.Set("series", entry.Series)
+		query.Set("season", fmt.Sprint(entry.Season))
+		query.Set("episode", fmt.Sprint(entry.Episode))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%v/media/lookup/?%s", API_BASE_URL, query.Encode()), nil)
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+CurrentToken())
+	resp, err := authClient.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		serverLookupMu.Lock()
+		serverLookupMissing = true
+		serverLookupMu.Unlock()
+		return "", false, nil
+	default:
+		return "", false, fmt.Errorf("%s", resp.Status)
+	}
+
+	var result struct {
+		Exists    bool   `json:"exists"`
+		Title     string `json:"title"`
+		Directory string `json:"directory"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return "", false, fmt.Errorf("unexpected lookup response: %w", err)
+	}
+	if !result.Exists {
+		return "", false, nil
+	}
+	return fmt.Sprintf("the server already has %q in %q", result.Title, result.Directory), true, nil
+}
+
+// SkipDuplicate reports when name was uploaded before and decides from the
+// onDuplicate setting, or by asking, whether to leave it out
+func SkipDuplicate(r *bufio.Reader, name string, entry MediaIndexEntry, archiveSum string) bool {
+	kept := entry.Fingerprint
+	if kept == "" {
+		kept = archiveSum
+	}
+	if AppConfig.OnDuplicate == duplicateUpload || keptDuplicates[kept] {
+		return false
+	}
+	reason, found := FindDuplicate(entry, archiveSum)
+	if !found {
+		return false
+	}
+	fmt.Printf("%s looks like a duplicate: %s\n", name, reason)
+	if AppConfig.OnDuplicate == duplicateSkip {
+		fmt.Printf("Skipping %s\n", name)
+		return true
+	}
+	choice := GetInputWithPrompt(r, "Skip it? (y/n):", "y")
+	if choice == "y" || choice == "Y" {
+		return true
+	}
+	if kept != "" {
+		keptDuplicates[kept] = true
+	}
+	return false
+}
@@ -2,15 +2,13 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/cookiejar"
 	"os"
 	"os/exec"
-	"path"
-	"path/filepath"
 	"strings"
 	"time"
 )
@@ -19,19 +17,21 @@ var API_BASE_URL string
 var Username string
 var Password string
 var Token string
-var UseMulti bool
 
 func main() {
+	flag.StringVar(&ProfileName, "profile", "", "name of the connection profile to use")
+	RegisterConfigFlags()
+	flag.Usage = func() {
+		fmt.Print(PrintUsage())
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
 	reader := bufio.NewReader(os.Stdin)
-	jar, err := cookiejar.New(nil)
-	if err != nil {
-		fmt.Println("Error creating jar")
+	if flag.NArg() > 0 {
+		RunCommand(reader, flag.Args())
 		return
 	}
-	client := &http.Client{
-		Timeout: time.Minute * 60,
-		Jar:     jar,
-	}
 
 	introBlock := "" +
 		"##############################################################################################" +
@@ -50,29 +50,65 @@ func main() {
 	}
 
 	// Load configuration
-	UseMulti, UseHardwareAccel, err = LoadConfig()
-	if err != nil {
-		fmt.Println("Error loading configuration:", err)
+	config, err := LoadConfig()
+	AppConfig = config
+	if os.IsNotExist(err) {
+		// Only the answers are saved, environment and flag overrides are for this run
+		config, _ = loadConfigFile()
 		resp := strings.ToLower(GetInputWithPrompt(reader, "do you wish to use multithreading?y/n", "y"))
 		if resp == "y" || resp == "yes" {
-			UseMulti = true
+			config.UseMulti = true
 		}
 		resp = strings.ToLower(GetInputWithPrompt(reader, "do you wish to use hardware acceleration(experimental)?y/n", "n"))
 		if resp == "y" || resp == "yes" {
-			UseHardwareAccel = true
+			config.UseHardwareAccel = true
+		}
+		resp = strings.ToLower(GetInputWithPrompt(reader, "do you wish to generate seek preview thumbnails for videos?y/n", "n"))
+		if resp == "y" || resp == "yes" {
+			config.UseTrickplay = true
 		}
 		// Save configuration
-		err = SaveConfig(UseMulti, UseHardwareAccel)
+		err = SaveConfig(config)
 		if err != nil {
 			fmt.Println("Error saving configuration:", err)
 			return
 		}
+		fmt.Printf("Configuration saved to %s, change it with fcli config set\n", configPath())
+		AppConfig, err = ApplyConfigOverrides(config)
+		if err != nil {
+			fmt.Println("Error loading configuration:", err)
+			return
+		}
+	} else if err != nil {
+		fmt.Println("Error loading configuration:", err)
+		return
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		fmt.Println("Error creating jar")
+		return
 	}
+	client := &http.Client{
+		Timeout: time.Duration(AppConfig.LoginTimeout),
+		Jar:     jar,
+	}
+
+	ctx, stop := NotifyInterrupt()
+	defer stop()
 
 	if HandleLogin(reader, jar, client) {
-		files, ok := HandleTranscoding(reader)
-		if ok {
-			res := HandleUpload(reader, files)
+		files, ok := HandleTranscoding(ctx, reader)
+		if !ok {
+			failedTranscodes.print()
+		} else {
+			res := HandleUpload(ctx, reader, files)
+			if ctx.Err() != nil {
+				fmt.Println("Interrupted, run FCLI in this folder again to carry on")
+				return
+			}
+			// Last, so the metadata prompts and upload progress don't push it out of view
+			failedTranscodes.print()
 			if res {
 				GetInputWithPrompt(reader, "Upload complete")
 				return
@@ -82,6 +118,40 @@ func main() {
 	}
 }
 
+// RunCommand handles the subcommands that don't start an upload
+func RunCommand(r *bufio.Reader, args []string) {
+	switch args[0] {
+	case "logout":
+		HandleLogout(args[1:])
+	case "profile":
+		HandleProfileCommand(r, args[1:])
+	case "config":
+		HandleConfigCommand(args[1:])
+	case "history":
+		HandleHistoryCommand(args[1:])
+	default:
+		fmt.Printf("Unknown command %s\n", args[0])
+		fmt.Print(PrintUsage())
+	}
+}
+
+func PrintUsage() string {
+	usage := "" +
+		"\nUsage: " +
+		"\n\tfcli [--profile name] - upload the media in a folder" +
+		"\n\tfcli logout [url] - revoke and forget saved login sessions" +
+		"\n\tfcli profile list - show the saved connection profiles" +
+		"\n\tfcli profile add [name] - save a new connection profile" +
+		"\n\tfcli profile remove <name> - delete a profile and its saved password" +
+		"\n\tfcli profile use <name> - make a profile the default" +
+		"\n\tfcli profile set <name> <key> <value> - change url, username, TLS, proxy or header settings of a profile" +
+		"\n\tfcli config show - print the configuration in use" +
+		"\n\tfcli config set <key> <value> - change a setting in the config file" +
+		"\n\tfcli history [--status s] [--search text] [--since 48h] [--limit n] [--json] - list what was transcoded and uploaded" +
+		"\nEvery setting can also be overridden with an FCLI_* environment variable or a flag\n"
+	return usage
+}
+
 func CheckFFmpegInstallation() bool {
 	cmd := exec.Command("ffmpeg", "-version")
 	err := cmd.Run()
@@ -101,50 +171,3 @@ func GetInputWithPrompt(r *bufio.Reader, prompt string, defaultChoice ...string)
 	}
 	return line
 }
-func LoadConfig() (bool, bool, error) {
-	execPath, err := os.Executable()
-	if err != nil {
-		log.Fatal(err)
-	}
-	execDir := filepath.Dir(execPath)
-	configPath := path.Join(execDir, "config.json")
-	file, err := os.Open(configPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return false, false, err // Default values if config doesn't exist
-		}
-		return false, false, err
-	}
-	defer file.Close()
-
-	config := map[string]bool{}
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&config)
-	if err != nil {
-		return false, false, err
-	}
-
-	return config["useMulti"], config["useHardwareAccel"], nil
-}
-
-func SaveConfig(useMulti, useHardwareAccel bool) error {
-	execPath, err := os.Executable()
-	if err != nil {
-		log.Fatal(err)
-	}
-	execDir := filepath.Dir(execPath)
-	configPath := path.Join(execDir, "config.json")
-	config := map[string]bool{
-		"useMulti":         useMulti,
-		"useHardwareAccel": useHardwareAccel,
-	}
-
-	file, err := os.Create(configPath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-	return encoder.Encode(config)
-}
@@ -8,7 +8,11 @@ import (
 	"log"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 const metadataFileName = "metadata.txt"
@@ -23,6 +27,18 @@ type MediaIndexEntry struct {
 	Directory   string   `json:"directory"`
 	Location    string   `json:"location"`
 	MediaType   string   `json:"mediaType"`
+	Series      string   `json:"series"`
+	Season      int      `json:"season"`
+	Episode     int      `json:"episode"`
+	Year        int      `json:"year"`
+	Runtime     int      `json:"runtime"` // seconds
+	Language    string   `json:"language"`
+	Resolution  string   `json:"resolution"`
+	Poster      string   `json:"poster"`                // path inside the package
+	Thumbnail   string   `json:"thumbnail"`             // path inside the package
+	Trickplay   string   `json:"trickplay"`             // WebVTT seek preview index inside the package
+	Chapters    string   `json:"chapters"`              // chapter list inside the package
+	Fingerprint string   `json:"fingerprint,omitempty"` // SourceFingerprint of the media file
 }
 
 var Description string
@@ -30,22 +46,50 @@ var Genre []string
 var Tags []string
 var Directory string
 var MediaType string
+var Series string
+
+// sourceEntries holds the per file metadata detected from each source, keyed by zip path
+var sourceEntries = map[string]MediaIndexEntry{}
+var sourceEntriesMu sync.Mutex
+
+var (
+	episodePattern    = regexp.MustCompile(`(?i)^(.*?)[\s._-]*s(\d{1,2})[\s._-]*e(\d{1,3})`)
+	altEpisodePattern = regexp.MustCompile(`(?i)^(.*?)[\s._-]+(\d{1,2})x(\d{2,3})(?:\D|$)`)
+	yearPattern       = regexp.MustCompile(`[(\[\s._-]((?:19|20)\d{2})(?:[)\]\s._-]|$)`)
+)
+
+// ParsedName is what can be worked out from a media file name alone.
+type ParsedName struct {
+	Title   string
+	Series  string
+	Season  int
+	Episode int
+	Year    int
+}
 
 func GenerateMetaData(r *bufio.Reader) {
 	metadataPath = path.Join(cwd, metadataFileName)
 	// Check for existing metadata file
 	existingMetadata := loadMetadataFromFile()
-	fmt.Printf("existing metadata found! Leave answer blank to reuse value\ndescription: %v\ngenres: %v\ntags: %v\ndirectory: %v\n",
+	if existingMetadata.Directory == "" {
+		existingMetadata.Directory = AppConfig.DefaultDirectory
+	}
+	if existingMetadata.MediaType == "" {
+		existingMetadata.MediaType = AppConfig.DefaultMediaType
+	}
+	fmt.Printf("existing metadata found! Leave answer blank to reuse value\ndescription: %v\ngenres: %v\ntags: %v\ndirectory: %v\nseries: %v\n",
 		existingMetadata.Description,
 		strings.Join(existingMetadata.Genre, " "),
 		strings.Join(existingMetadata.Tags, " "),
-		existingMetadata.Directory)
+		existingMetadata.Directory,
+		existingMetadata.Series)
 	Description = GetInputWithPrompt(r, "Enter the description:", existingMetadata.Description)
 	genreInput := GetInputWithPrompt(r, "Enter the genres (separated by spaces):", strings.Join(existingMetadata.Genre, " "))
 	Genre = strings.Fields(genreInput)
 	tagsInput := GetInputWithPrompt(r, "Enter the tags (separated by spaces):", strings.Join(existingMetadata.Tags, " "))
 	Tags = strings.Fields(tagsInput)
 	Directory = GetInputWithPrompt(r, "Enter the directory this should be placed in on the server:", existingMetadata.Directory)
+	Series = GetInputWithPrompt(r, "Enter the series name (leave blank to detect it from file names):", existingMetadata.Series)
 	// Ensure MediaType is either "video" or "audio"
 	for {
 		MediaType = GetInputWithPrompt(r, "Enter the media type (video/audio):", existingMetadata.MediaType)
@@ -62,18 +106,234 @@ func GenerateMetaData(r *bufio.Reader) {
 		Tags:        Tags,
 		Directory:   Directory,
 		MediaType:   MediaType,
+		Series:      Series,
 	})
 }
 
-func AttachMetaData(filename string) MediaIndexEntry {
-	return MediaIndexEntry{
-		Title:       strings.TrimSuffix(filename, ".zip"),
+func AttachMetaData(zipFile string) MediaIndexEntry {
+	source := lookupSourceEntry(zipFile)
+	entry := MediaIndexEntry{
+		Title:       packageStem(zipFile),
 		Description: Description,
 		Genre:       Genre,
 		Tags:        Tags,
 		Directory:   Directory,
 		MediaType:   MediaType,
+		Series:      source.Series,
+		Season:      source.Season,
+		Episode:     source.Episode,
+		Year:        source.Year,
+		Runtime:     source.Runtime,
+		Language:    source.Language,
+		Resolution:  source.Resolution,
+		Poster:      source.Poster,
+		Thumbnail:   source.Thumbnail,
+		Trickplay:   source.Trickplay,
+		Chapters:    source.Chapters,
+		Fingerprint: source.Fingerprint,
+	}
+	if Series != "" {
+		entry.Series = Series
+	}
+	// Values found in the file or its nfo are more specific than the ones for the whole folder
+	if source.Title != "" {
+		entry.Title = source.Title
+	}
+	if source.Description != "" {
+		entry.Description = source.Description
+	}
+	if len(source.Genre) > 0 {
+		entry.Genre = source.Genre
+	}
+	if len(source.Tags) > 0 {
+		entry.Tags = source.Tags
+	}
+	return entry
+}
+
+// ConfirmFileMetaData lets the user review the title and description found
+// inside each file before it is uploaded. Files without any are skipped.
+func ConfirmFileMetaData(r *bufio.Reader, zipFiles []string) {
+	for _, zipFile := range zipFiles {
+		entry := lookupSourceEntry(zipFile)
+		if entry.Title == "" && entry.Description == "" {
+			continue
+		}
+		fmt.Printf("Metadata found for %s. Leave answer blank to reuse value\ntitle: %v\ndescription: %v\n",
+			filepath.Base(zipFile), entry.Title, entry.Description)
+		defaultTitle := entry.Title
+		if defaultTitle == "" {
+			defaultTitle = packageStem(zipFile)
+		}
+		defaultDescription := entry.Description
+		if defaultDescription == "" {
+			defaultDescription = Description
+		}
+		entry.Title = GetInputWithPrompt(r, "Enter the title:", defaultTitle)
+		entry.Description = GetInputWithPrompt(r, "Enter the description:", defaultDescription)
+		recordSourceEntry(zipFile, entry)
+	}
+}
+
+// DescribeSource gathers everything that can be detected automatically about a
+// media file. The language is the one of audioTrack, the first one when empty.
+func DescribeSource(inputFile, audioTrack string) MediaIndexEntry {
+	// Later sources win: file name, then container tags, then a nfo file.
+	// Metadata providers only fill what is still missing after that.
+	parsed := ParseMediaName(filepath.Base(inputFile))
+	entry := entryFromName(filepath.Base(inputFile))
+	probe, err := ProbeMedia(inputFile)
+	if err != nil {
+		log.Printf("Error probing file %s: %v", inputFile, err)
+	} else {
+		entry.Runtime = probe.DurationSeconds()
+		entry.Language = probe.Language(audioTrackIndex(audioTrack))
+		entry.Resolution = probe.Resolution()
+		applyContainerTags(&entry, probe.Format.Tags)
+	}
+	if info, ok := readNfo(inputFile); ok {
+		applyNfo(&entry, info)
+	}
+	entry.Fingerprint, err = SourceFingerprint(inputFile)
+	if err != nil {
+		log.Printf("Error fingerprinting file %s: %v", inputFile, err)
+	}
+
+	query := MetadataQuery{
+		Title:   parsed.Title,
+		Series:  entry.Series,
+		Year:    entry.Year,
+		Season:  entry.Season,
+		Episode: entry.Episode,
+	}
+	if entry.Title != "" {
+		query.Title = entry.Title
+	}
+	EnrichMetaData(&entry, query)
+	return entry
+}
+
+func ParseMediaName(fileName string) ParsedName {
+	name := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	var parsed ParsedName
+
+	if m := yearPattern.FindStringSubmatchIndex(name); m != nil {
+		parsed.Year, _ = strconv.Atoi(name[m[2]:m[3]])
+	}
+
+	if m := episodePattern.FindStringSubmatch(name); m != nil {
+		parsed.Series = cleanMediaName(m[1])
+		parsed.Season, _ = strconv.Atoi(m[2])
+		parsed.Episode, _ = strconv.Atoi(m[3])
+	} else if m := altEpisodePattern.FindStringSubmatch(name); m != nil {
+		parsed.Series = cleanMediaName(m[1])
+		parsed.Season, _ = strconv.Atoi(m[2])
+		parsed.Episode, _ = strconv.Atoi(m[3])
+	}
+
+	title := name
+	if parsed.Series == "" {
+		// For films everything after the year is usually release info
+		if m := yearPattern.FindStringIndex(name); m != nil && m[0] > 0 {
+			title = name[:m[0]]
+		}
+	}
+	parsed.Title = cleanMediaName(title)
+	if parsed.Series != "" {
+		// Don't let the year stick to the series name, e.g. "Show (2019)"
+		if m := yearPattern.FindStringIndex(parsed.Series); m != nil && m[0] > 0 {
+			parsed.Series = cleanMediaName(parsed.Series[:m[0]])
+		}
+	}
+	return parsed
+}
+
+func cleanMediaName(name string) string {
+	name = strings.NewReplacer(".", " ", "_", " ").Replace(name)
+	return strings.Trim(strings.Join(strings.Fields(name), " "), " -([")
+}
+
+func recordSourceEntry(zipFile string, entry MediaIndexEntry) {
+	sourceEntriesMu.Lock()
+	defer sourceEntriesMu.Unlock()
+	sourceEntries[zipFile] = entry
+}
+
+func moveSourceEntry(oldZip, newZip string) {
+	sourceEntriesMu.Lock()
+	defer sourceEntriesMu.Unlock()
+	if entry, ok := sourceEntries[oldZip]; ok {
+		delete(sourceEntries, oldZip)
+		sourceEntries[newZip] = entry
+	}
+}
+
+// lookupSourceEntry returns the detected metadata for a zip, probing the
+// matching source file when the zip was made on an earlier run
+func lookupSourceEntry(zipFile string) MediaIndexEntry {
+	sourceEntriesMu.Lock()
+	entry, ok := sourceEntries[zipFile]
+	sourceEntriesMu.Unlock()
+	if ok {
+		return entry
+	}
+
+	source := findSourceFor(zipFile)
+	if source == "" {
+		return entryFromName(filepath.Base(zipFile))
+	}
+	state, _ := pipeline.Get(pipeline.KeyForZip(zipFile))
+	entry = DescribeSource(source, state.AudioTrack)
+	recordSourceEntry(zipFile, entry)
+	return entry
+}
+
+// AudioTrackLanguage returns the language of the audio track picked for transcoding
+func AudioTrackLanguage(inputFile, audioTrack string) string {
+	probe, err := ProbeMedia(inputFile)
+	if err != nil {
+		log.Printf("Error probing file %s: %v", inputFile, err)
+		return ""
+	}
+	return probe.Language(audioTrackIndex(audioTrack))
+}
+
+func audioTrackIndex(audioTrack string) int {
+	index, err := strconv.Atoi(audioTrack)
+	if err != nil {
+		return 0
+	}
+	return index
+}
+
+func entryFromName(fileName string) MediaIndexEntry {
+	parsed := ParseMediaName(fileName)
+	return MediaIndexEntry{
+		Series:  parsed.Series,
+		Season:  parsed.Season,
+		Episode: parsed.Episode,
+		Year:    parsed.Year,
+	}
+}
+
+func findSourceFor(zipFile string) string {
+	// Output uploaded as a directory may be in the temp dir, away from its source
+	if state, ok := pipeline.Get(pipeline.KeyForZip(zipFile)); ok && state.Source != "" {
+		return state.Source
+	}
+	dir := filepath.Dir(zipFile)
+	stem := packageStem(zipFile)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, file := range files {
+		fn := file.Name()
+		if !file.IsDir() && isMediaFile(fn) && strings.TrimSuffix(fn, filepath.Ext(fn)) == stem {
+			return filepath.Join(dir, fn)
+		}
 	}
+	return ""
 }
 
 func loadMetadataFromFile() MediaIndexEntry {
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const historyFileName = "history.jsonl"
+
+// Statuses recorded in the history
+const (
+	statusTranscoded      = "transcoded"
+	statusTranscodeFailed = "transcode failed"
+	statusUploaded        = "uploaded"
+	statusUploadFailed    = "upload failed"
+	statusInterrupted     = "interrupted"
+)
+
+// HistoryEntry is one line of history.jsonl. Every step of a file, from
+// transcoding to uploading, adds an entry.
+type HistoryEntry struct {
+	Time          time.Time `json:"time"`
+	Status        string    `json:"status"`
+	Source        string    `json:"source,omitempty"`      // the media file
+	Fingerprint   string    `json:"fingerprint,omitempty"` // SourceFingerprint of the media file
+	Subtitle      string    `json:"subtitle,omitempty"`
+	AudioTrack    string    `json:"audioTrack,omitempty"`
+	EncodeProfile string    `json:"encodeProfile,omitempty"`
+	Zip           string    `json:"zip,omitempty"`
+	Size          int64     `json:"size,omitempty"`
+	SHA256        string    `json:"sha256,omitempty"`
+	ChunkSize     int64     `json:"chunkSize,omitempty"`
+	Chunks        []string  `json:"chunks,omitempty"` // SHA-256 of every uploaded chunk
+	URL           string    `json:"url,omitempty"`
+	Title         string    `json:"title,omitempty"`
+	Directory     string    `json:"directory,omitempty"`
+	Series        string    `json:"series,omitempty"`
+	Season        int       `json:"season,omitempty"`
+	Episode       int       `json:"episode,omitempty"`
+	Year          int       `json:"year,omitempty"`
+	Response      string    `json:"response,omitempty"` // what the server answered to the last chunk
+	Error         string    `json:"error,omitempty"`
+}
+
+var historyMu sync.Mutex
+
+func historyPath() string {
+	return filepath.Join(ConfigDir(), historyFileName)
+}
+
+// RecordHistory appends entry to history.jsonl in ConfigDir
+func RecordHistory(entry HistoryEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	f, err := os.OpenFile(historyPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadHistory reads history.jsonl oldest first, skipping lines it can't parse
+func LoadHistory() ([]HistoryEntry, error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	f, err := os.Open(historyPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// HandleHistoryCommand implements fcli history, newest entries first
+func HandleHistoryCommand(args []string) {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	status := flags.String("status", "", "only show entries with this status, e.g. uploaded or \"upload failed\"")
+	search := flags.String("search", "", "only show entries whose title, source, zip or directory contain this")
+	since := flags.String("since", "", "only show entries after a date (2006-01-02) or within a duration (48h)")
+	limit := flags.Int("limit", 50, "show at most this many entries, 0 for all")
+	asJSON := flags.Bool("json", false, "print the entries as JSON lines")
+	if err := flags.Parse(args); err != nil {
+		return
+	}
+
+	var after time.Time
+	if *since != "" {
+		if d, err := time.ParseDuration(*since); err == nil {
+			after = time.Now().Add(-d)
+		} else if t, err := time.ParseInLocation("2006-01-02", *since, time.Local); err == nil {
+			after = t
+		} else {
+			fmt.Printf("Invalid --since %q, expected a date like 2006-01-02 or a duration like 48h\n", *since)
+			return
+		}
+	}
+
+	entries, err := LoadHistory()
+	if err != nil {
+		fmt.Println("Error reading history:", err)
+		return
+	}
+	shown := 0
+	for i := len(entries) - 1; i >= 0 && (*limit <= 0 || shown < *limit); i-- {
+		entry := entries[i]
+		if *status != "" && !strings.EqualFold(entry.Status, *status) {
+			continue
+		}
+		if entry.Time.Before(after) {
+			continue
+		}
+		if *search != "" && !entry.matches(*search) {
+			continue
+		}
+		shown++
+		if *asJSON {
+			line, _ := json.Marshal(entry)
+			fmt.Println(string(line))
+			continue
+		}
+		fmt.Println(entry)
+	}
+	if shown == 0 && !*asJSON {
+		fmt.Println("No matching history")
+	}
+}
+
+func (e HistoryEntry) matches(search string) bool {
+	search = strings.ToLower(search)
+	for _, field := range []string{e.Title, e.Source, e.Zip, e.Directory, e.Series} {
+		if strings.Contains(strings.ToLower(field), search) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e HistoryEntry) String() string {
+	name := e.Title
+	if name == "" {
+		name = filepath.Base(e.Zip)
+	}
+	if name == "" || name == "." {
+		name = filepath.Base(e.Source)
+	}
+	line := fmt.Sprintf("%s  %-16s %s", e.Time.Local().Format("2006-01-02 15:04"), e.Status, name)
+	if e.Directory != "" {
+		line += " in " + e.Directory
+	}
+	if e.URL != "" {
+		line += " on " + e.URL
+	}
+	if e.Error != "" {
+		// Older entries kept the whole ffmpeg log
+		message, _, _ := strings.Cut(e.Error, "\n")
+		line += "\n\t" + message
+	}
+	return line
+}
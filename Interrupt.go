@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// busy counts the transcodes and uploads running. Ctrl-C lets them clean up
+// first, anywhere else (like at a prompt) FCLI quits right away.
+var busy atomic.Int32
+
+// NotifyInterrupt returns a context that is cancelled by Ctrl-C, SIGTERM or,
+// outside of windows, SIGHUP. A second signal quits without waiting for the cleanup.
+func NotifyInterrupt() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, stopSignals...)
+	go func() {
+		sig := <-signals
+		cancel()
+		if busy.Load() == 0 {
+			fmt.Println("\nInterrupted")
+			os.Exit(exitCode(sig))
+		}
+		fmt.Println("\nStopping, cleaning up unfinished files (press Ctrl-C again to quit immediately)")
+		sig = <-signals
+		os.Exit(exitCode(sig))
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// exitCode is what shells report for a process killed by sig, 130 for Ctrl-C
+func exitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 130
+}
+
+// startWork marks a stretch of work that cleans up after itself when
+// cancelled, call the returned function when it is done
+func startWork() func() {
+	busy.Add(1)
+	return func() { busy.Add(-1) }
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vbauerster/mpb/v8"
+)
+
+// maxThrottledRead keeps single reads small so the limit is applied smoothly
+const maxThrottledRead = 32 * 1024
+
+// RateLimiter spreads bytes from all uploads over time so together they stay
+// under the configured rate
+type RateLimiter struct {
+	mu   sync.Mutex
+	rate Rate
+	next time.Time // when the bytes already handed out have been paid for
+}
+
+func NewRateLimiter(rate Rate) *RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &RateLimiter{rate: rate}
+}
+
+// Wait blocks until n more bytes may be sent or ctx is done. A nil limiter never waits.
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / int64(l.rate)))
+	l.mu.Unlock()
+	return sleepContext(ctx, wait)
+}
+
+// sleepContext is time.Sleep that ends early with ctx's error when ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttledReader applies the limiter and moves the progress bar while a
+// chunk is read by the HTTP client, so the bar's speed and ETA show the real
+// (limited) transfer rate
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *RateLimiter
+	timer   *requestTimer
+	bar     *mpb.Bar
+	last    time.Time
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > maxThrottledRead {
+		p = p[:maxThrottledRead]
+	}
+	n, err := t.r.Read(p)
+	t.timer.pause()
+	waitErr := t.limiter.Wait(t.ctx, n)
+	t.timer.resume()
+	if waitErr != nil {
+		return n, waitErr
+	}
+	if t.bar != nil && n > 0 {
+		now := time.Now()
+		t.bar.EwmaIncrBy(n, now.Sub(t.last))
+		t.last = now
+	}
+	return n, err
+}
+
+// requestTimer runs cancel once a request took longer than its timeout. The
+// time a request is held back by the rate limiter doesn't count, with many
+// files sharing a low limit that can be far longer than the timeout.
+type requestTimer struct {
+	mu        sync.Mutex
+	timer     *time.Timer
+	remaining time.Duration
+	started   time.Time
+	paused    int
+	expired   bool
+	stopped   bool
+}
+
+func newRequestTimer(timeout time.Duration, cancel func()) *requestTimer {
+	t := &requestTimer{remaining: timeout, started: time.Now()}
+	t.timer = time.AfterFunc(timeout, func() {
+		t.mu.Lock()
+		t.expired = true
+		t.mu.Unlock()
+		cancel()
+	})
+	return t
+}
+
+func (t *requestTimer) pause() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.paused == 0 && t.timer.Stop() {
+		t.remaining -= time.Since(t.started)
+	}
+	t.paused++
+}
+
+func (t *requestTimer) resume() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.paused--
+	if t.paused == 0 && !t.expired && !t.stopped {
+		t.started = time.Now()
+		t.timer.Reset(t.remaining)
+	}
+}
+
+// stop ends the timer and reports whether it had run out
+func (t *requestTimer) stop() bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timer.Stop()
+	t.stopped = true
+	return t.expired
+}
+
+// UploadWindow is a daily time range like 22:00-06:00 in local time
+type UploadWindow struct {
+	Start, End time.Duration // since midnight
+}
+
+// ParseUploadWindows reads a comma separated list of windows, an empty string
+// means uploads may run at any time
+func ParseUploadWindows(s string) ([]UploadWindow, error) {
+	var windows []UploadWindow
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(part, "-")
+		start, errStart := parseClock(from)
+		end, errEnd := parseClock(to)
+		if !ok || errStart != nil || errEnd != nil || start == end {
+			return nil, fmt.Errorf("invalid upload window %q, expected something like 22:00-06:00", part)
+		}
+		windows = append(windows, UploadWindow{Start: start, End: end})
+	}
+	return windows, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	hours, minutes, _ := strings.Cut(strings.TrimSpace(s), ":")
+	h, err := strconv.Atoi(hours)
+	if err != nil || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	m := 0
+	if minutes != "" {
+		m, err = strconv.Atoi(minutes)
+		if err != nil || m < 0 || m > 59 {
+			return 0, fmt.Errorf("invalid time %q", s)
+		}
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// untilOpen is how long until one of the windows is open, 0 when one is now
+func untilOpen(windows []UploadWindow, now time.Time) time.Duration {
+	if len(windows) == 0 {
+		return 0
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	clock := now.Sub(midnight)
+	wait := 24 * time.Hour
+	for _, w := range windows {
+		// Windows like 22:00-06:00 run past midnight
+		open := clock >= w.Start && clock < w.End
+		if w.Start > w.End {
+			open = clock >= w.Start || clock < w.End
+		}
+		if open {
+			return 0
+		}
+		untilStart := w.Start - clock
+		if untilStart < 0 {
+			untilStart += 24 * time.Hour
+		}
+		wait = min(wait, untilStart)
+	}
+	return wait
+}
+
+var windowMu sync.Mutex
+
+// WaitForUploadWindow sleeps until uploads are allowed. Uploads waiting at the
+// same time share a single message.
+func WaitForUploadWindow(ctx context.Context, windows []UploadWindow) error {
+	windowMu.Lock()
+	defer windowMu.Unlock()
+	wait := untilOpen(windows, time.Now())
+	if wait == 0 {
+		return nil
+	}
+	fmt.Printf("Outside of the upload window %s, waiting until %s\n",
+		AppConfig.UploadWindow, time.Now().Add(wait).Format("15:04"))
+	return sleepContext(ctx, wait)
+}
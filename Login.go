@@ -2,166 +2,306 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"golang.org/x/term"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
-func HandleLogin(reader *bufio.Reader, jar *cookiejar.Jar, client *http.Client) bool {
-	execPath, err := os.Executable()
-	if err != nil {
-		log.Fatal(err)
-	}
-	execDir := filepath.Dir(execPath)
+// passwordRef points at the saved password of the selected connection
+var passwordRef string
 
-	// List all credential files
-	files, err := os.ReadDir(execDir)
+// The login client and jar are kept so the session can be refreshed during uploads
+var authClient *http.Client
+var authJar *cookiejar.Jar
+
+func HandleLogin(reader *bufio.Reader, jar *cookiejar.Jar, client *http.Client) bool {
+	authJar = jar
+	authClient = client
+	store, err := LoadProfiles()
 	if err != nil {
-		log.Fatal(err)
+		fmt.Println("Error loading profiles:", err)
+		return false
 	}
+	importLegacyConnectionFiles(reader, &store)
 
-	var credsFiles []string
-	for _, file := range files {
-		if strings.HasSuffix(file.Name(), ".fn") {
-			credsFiles = append(credsFiles, file.Name())
+	if ProfileName != "" {
+		p, ok := store.Profiles[ProfileName]
+		if !ok {
+			fmt.Printf("No profile named %s\n", ProfileName)
+			return false
 		}
-	}
-
-	if len(credsFiles) == 0 {
-		fmt.Println("No credentials files found.")
-		HandleConnectionInfo(reader)
-
+		UseProfile(ProfileName, p)
+	} else if len(store.Profiles) == 0 {
+		fmt.Println("No saved profiles found.")
+		HandleConnectionInfo(reader, &store)
 	} else {
-		fmt.Println("Available credentials files:")
-		for i, file := range credsFiles {
-			fmt.Printf("%d: %s\n", i+1, file)
+		names := store.Names()
+		defaultChoice := ""
+		fmt.Println("Available profiles:")
+		for i, name := range names {
+			p := store.Profiles[name]
+			marker := ""
+			if name == store.Default {
+				marker = " (default)"
+				defaultChoice = strconv.Itoa(i + 1)
+			}
+			fmt.Printf("%d: %s - %s@%s%s\n", i+1, name, p.Username, p.URL, marker)
 		}
 
-		choice := GetInputWithPrompt(reader, "Select a credentials file by number (0 for new): ")
+		choice := GetInputWithPrompt(reader, "Select a profile by number (0 for new): ", defaultChoice)
 		index, err := strconv.Atoi(choice)
-		if err != nil || index < 0 || index > len(credsFiles) {
+		if err != nil || index < 0 || index > len(names) {
 			fmt.Println("Invalid choice.")
 			return false
 		}
 		if index == 0 {
-			HandleConnectionInfo(reader)
+			HandleConnectionInfo(reader, &store)
 		} else {
-			credsPath := filepath.Join(execDir, credsFiles[index-1])
-			file, err := os.Open(credsPath)
-			if err != nil {
-				log.Fatal(err)
-			}
-			defer file.Close()
-
-			scanner := bufio.NewScanner(file)
-			var lines []string
-			lines = make([]string, 2)
-			i := 0
-			for scanner.Scan() {
-				lines[i] = scanner.Text()
-				i++
-			}
+			UseProfile(names[index-1], store.Profiles[names[index-1]])
+		}
+	}
 
-			if err := scanner.Err(); err != nil {
-				log.Fatal(err)
-			}
-			if !SetConnectionFromFile(lines) {
-				HandleConnectionInfo(reader)
-			}
+	transport, err := NewTransport(CurrentProfile)
+	if err != nil {
+		fmt.Println("Error setting up the connection:", err)
+		return false
+	}
+	authClient.Transport = transport
+
+	if session, ok := CachedSession(); ok {
+		fmt.Println("Reusing saved login session")
+		Token = session.Token
+		return true
+	}
+
+	reentered := false
+	for {
+		err := login(reader)
+		if err == nil {
+			break
 		}
+		fmt.Println(err)
+		resp := strings.ToLower(GetInputWithPrompt(reader, "Do you want to re-enter the connection details and try again? y/n", "y"))
+		if resp != "y" && resp != "yes" {
+			return false
+		}
+		reenterConnectionInfo(reader, err)
+		reentered = true
+	}
+	if reentered && ProfileName != "" {
+		SaveConnectionInfo(reader, &store, ProfileName)
 	}
+	return true
+}
 
-	requestURL := fmt.Sprintf("%v/login/", API_BASE_URL)
+// LoginError explains why logging in failed in terms the user can act on
+type LoginError struct {
+	Credentials bool // the server rejected the username or password
+	Message     string
+}
+
+func (e *LoginError) Error() string {
+	return "Login failed: " + e.Message
+}
+
+// Authenticate logs in with the username and password and caches the session
+// token so the next run doesn't need the password.
+func Authenticate(reader *bufio.Reader) bool {
+	if err := login(reader); err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return true
+}
+
+func login(reader *bufio.Reader) error {
+	ensurePassword(reader)
+	requestURL := fmt.Sprintf("%v/login/", strings.TrimSuffix(API_BASE_URL, "/"))
 	req, err := http.NewRequest("GET", requestURL, nil)
 	if err != nil {
-		log.Fatal(err)
+		return &LoginError{Message: fmt.Sprintf("%q is not a valid URL: %v", API_BASE_URL, err)}
 	}
 	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(Username+":"+Password)))
+
+	// Keep track of redirects, a login that ends up somewhere else usually means the URL is wrong
+	var redirects []string
+	client := *authClient
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		redirects = append(redirects, req.URL.String())
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		return nil
+	}
+
 	res, err := client.Do(req)
 	if err != nil {
-		fmt.Println(err)
-		return false
+		return explainRequestError(err, req.URL)
 	}
 	defer res.Body.Close()
 
+	token, expires := sessionCookie(res)
+	if token == "" || res.StatusCode != http.StatusOK {
+		return explainLoginResponse(res, redirects)
+	}
+	Token = token
+	if err := SaveSession(Token, expires); err != nil {
+		fmt.Printf("Error saving login session: %v\n", err)
+	}
+	return nil
+}
+
+func explainRequestError(err error, u *url.URL) error {
+	var dnsErr *net.DNSError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	var verifyErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var opErr *net.OpError
+	var pinErr *PinError
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &pinErr):
+		return &LoginError{Message: fmt.Sprintf("the certificate of %s does not match the pinned fingerprints, got %s. If the certificate was renewed update pinnedCerts with fcli profile set.", u.Host, pinErr.Fingerprint)}
+	case errors.As(err, &dnsErr):
+		return &LoginError{Message: fmt.Sprintf("could not find the host %s. Check the URL for typos and that you are on the right network.", u.Hostname())}
+	case errors.As(err, &unknownAuthority):
+		return &LoginError{Message: fmt.Sprintf("the certificate of %s is signed by an authority this computer does not trust. If the instance uses a private CA add it with fcli profile set <name> caFile <file>.", u.Host)}
+	case errors.As(err, &hostnameErr):
+		return &LoginError{Message: fmt.Sprintf("the certificate of %s is not valid for that name: %v", u.Host, hostnameErr)}
+	case errors.As(err, &invalidCert):
+		return &LoginError{Message: fmt.Sprintf("the certificate of %s is invalid, it may have expired: %v", u.Host, invalidCert)}
+	case errors.As(err, &verifyErr):
+		return &LoginError{Message: fmt.Sprintf("the certificate of %s could not be verified: %v", u.Host, verifyErr.Err)}
+	case errors.As(err, &recordErr), strings.Contains(err.Error(), "server gave HTTP response to HTTPS client"):
+		return &LoginError{Message: fmt.Sprintf("%s did not answer with TLS. Try http:// instead of https://", u.Host)}
+	case errors.Is(err, syscall.ECONNREFUSED), errors.As(err, &opErr) && opErr.Op == "dial":
+		return &LoginError{Message: fmt.Sprintf("could not connect to %s. Check that Farnsworth is running and the port is correct.", u.Host)}
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return &LoginError{Message: fmt.Sprintf("%s did not answer in time. Check the URL and your connection.", u.Host)}
+	}
+	return &LoginError{Message: err.Error()}
+}
+
+func explainLoginResponse(res *http.Response, redirects []string) error {
+	loginErr := &LoginError{}
+	switch {
+	case res.StatusCode == http.StatusUnauthorized:
+		loginErr.Credentials = true
+		loginErr.Message = "the username or password was rejected (401 Unauthorized)."
+	case res.StatusCode == http.StatusForbidden:
+		loginErr.Credentials = true
+		loginErr.Message = fmt.Sprintf("the account %s is not allowed to log in (403 Forbidden).", Username)
+	case res.StatusCode == http.StatusNotFound:
+		loginErr.Message = fmt.Sprintf("there is no login page at %s. Check that the URL points at the Farnsworth instance, including any path prefix.", res.Request.URL)
+	case res.StatusCode >= 500:
+		loginErr.Message = fmt.Sprintf("the server had an error (%s). Try again later or check the server logs.", res.Status)
+	case res.StatusCode != http.StatusOK:
+		loginErr.Message = fmt.Sprintf("unexpected response %s from the server.", res.Status)
+	default:
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		if strings.Contains(res.Header.Get("Content-Type"), "text/html") {
+			loginErr.Message = "the server answered with a web page instead of a login session. The URL may point at a proxy login page or a different site."
+		} else {
+			loginErr.Message = fmt.Sprintf("the server did not send an auth-token cookie. Response: %q", strings.TrimSpace(string(body)))
+		}
+	}
+	if len(redirects) > 0 {
+		loginErr.Message += fmt.Sprintf("\nThe login was redirected to %s, you may need to use that address instead.", redirects[len(redirects)-1])
+	}
+	return loginErr
+}
+
+// reenterConnectionInfo asks again for what most likely caused the failure.
+// Rejected credentials keep the URL, everything else asks for it too.
+func reenterConnectionInfo(r *bufio.Reader, err error) {
+	var loginErr *LoginError
+	if !errors.As(err, &loginErr) || !loginErr.Credentials {
+		API_BASE_URL = GetInputWithPrompt(r, "What is the URL of the instance you are connecting to?", API_BASE_URL)
+	}
+	Username = GetInputWithPrompt(r, "What is the username for the instance?", Username)
+	// Don't load the saved password again, it was rejected or belongs to the old details
+	Password = ""
+	passwordRef = ""
+}
+
+// sessionCookie finds the auth-token in the login response, falling back to the
+// jar when it was set on a redirect. Expiry is zero when the server did not send one.
+func sessionCookie(res *http.Response) (string, time.Time) {
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == "auth-token" && cookie.Value != "" {
+			if cookie.MaxAge > 0 {
+				return cookie.Value, time.Now().Add(time.Duration(cookie.MaxAge) * time.Second)
+			}
+			return cookie.Value, cookie.Expires
+		}
+	}
+
 	u, err := url.Parse(API_BASE_URL)
 	if err != nil {
 		log.Fatal(err)
 	}
-	cookies := jar.Cookies(u)
+	cookies := authJar.Cookies(u)
 	for _, cookie := range cookies {
 		if cookie.Name == "auth-token" {
-			Token = cookie.Value
+			return cookie.Value, time.Time{}
 		}
 	}
-	if Token == "" {
-		fmt.Println("Login failed")
-		return false
-	}
-	return true
+	return "", time.Time{}
 }
 
-func HandleConnectionInfo(r *bufio.Reader) {
+func HandleConnectionInfo(r *bufio.Reader, store *ProfileStore) {
 	API_BASE_URL = GetInputWithPrompt(r, "What is the URL of the instance you are connecting to?", "http://localhost:8080")
 	Username = GetInputWithPrompt(r, "What is the username for the instance?")
-	fmt.Println("What is the password for this instance?(password will be hidden)")
-	bytepw, err := term.ReadPassword(int(syscall.Stdin))
-	if err != nil {
-		os.Exit(1)
-	}
-	Password = string(bytepw)
-	resp := strings.ToLower(GetInputWithPrompt(r, "Do you wish to save this configuration, do not do this on shared computers? y/n", "y"))
+	Password = ""
+	passwordRef = ""
+	ProfileName = ""
+	CurrentProfile = Profile{URL: API_BASE_URL, Username: Username}
+	resp := strings.ToLower(GetInputWithPrompt(r, "Do you wish to save this connection as a profile? y/n", "y"))
 	if resp == "y" || resp == "yes" {
-		saved := SaveConnectionInfo(API_BASE_URL)
+		saved := SaveConnectionInfo(r, store, "")
 		fmt.Printf("saved correctly: %v\n", saved)
 	}
 }
 
-func SetConnectionFromFile(lines []string) bool {
-	API_BASE_URL = lines[0]
-	c, err := base64.StdEncoding.DecodeString(lines[1])
-	if err != nil {
-		fmt.Println("creds file corrupted or incorrect. Redirecting to login")
-		return false
-	}
-	cs := string(c)
-	username, password, ok := strings.Cut(cs, ":")
-	if !ok {
-		return false
-	}
-	Username = username
-	Password = password
-	return true
-}
-
-func SaveConnectionInfo(hostName string) bool {
-	// Remove http:// and https:// from the hostName
-	hostName = strings.TrimPrefix(hostName, "http://")
-	hostName = strings.TrimPrefix(hostName, "https://")
-	f, err := os.Create(fmt.Sprintf("./%v.fn", hostName))
-	if err != nil {
-		fmt.Printf("Error writing file: %v\n", err)
-		return false
+// ensurePassword loads the saved password or asks for it
+func ensurePassword(r *bufio.Reader) {
+	if Password != "" {
+		return
 	}
-	defer f.Close()
-	f.WriteString(API_BASE_URL + "\n")
-	if err != nil {
-		fmt.Printf("Error writing file: %v\n", err)
-		return false
+	if passwordRef != "" && passwordRef != noSecretRef {
+		password, err := LoadSecret(r, credentialAccount(), passwordRef)
+		if err == nil {
+			Password = password
+			return
+		}
+		fmt.Printf("Could not read the saved password: %v\n", err)
 	}
-	f.WriteString(base64.StdEncoding.EncodeToString([]byte(Username + ":" + Password)))
+	fmt.Println("What is the password for this instance?(password will be hidden)")
+	bytepw, err := term.ReadPassword(int(syscall.Stdin))
 	if err != nil {
-		fmt.Printf("Error writing file: %v\n", err)
-		return false
+		os.Exit(1)
 	}
-	return true
+	Password = string(bytepw)
+}
+
+// credentialAccount is the name the password is stored under in the keyring
+func credentialAccount() string {
+	return Username + "@" + API_BASE_URL
 }
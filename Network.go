@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// PinError is returned when the server certificate matches none of the pinned fingerprints
+type PinError struct {
+	Fingerprint string
+}
+
+func (e *PinError) Error() string {
+	return fmt.Sprintf("server certificate %s does not match any pinned fingerprint", e.Fingerprint)
+}
+
+// NewTransport builds the transport for talking to the instance of a profile,
+// applying its CA bundle, client certificate, pinned fingerprints, proxy and
+// extra headers
+func NewTransport(p Profile) (http.RoundTripper, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Uploads run one request per file in parallel, keep enough idle
+	// connections around that chunks don't each open a new one
+	transport.MaxIdleConns = 64
+	transport.MaxIdleConnsPerHost = 32
+	transport.IdleConnTimeout = 90 * time.Second
+	tlsConfig, err := profileTLSConfig(p)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	switch p.Proxy {
+	case "":
+		// HTTP_PROXY, HTTPS_PROXY and NO_PROXY from the environment
+	case "none":
+		transport.Proxy = nil
+	default:
+		proxyURL, err := url.Parse(p.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q, expected something like http://host:port or socks5://host:port", p.Proxy)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy type %s", proxyURL.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if len(p.Headers) == 0 {
+		return transport, nil
+	}
+	headers := http.Header{}
+	for name, value := range p.Headers {
+		headers.Set(name, value)
+	}
+	// The proxy credentials are only for the proxy. HTTPS requests send them
+	// with the CONNECT, inside the tunnel they would reach the server.
+	proxyAuth := headers.Get("Proxy-Authorization")
+	headers.Del("Proxy-Authorization")
+	if proxyAuth != "" {
+		transport.ProxyConnectHeader = http.Header{"Proxy-Authorization": {proxyAuth}}
+	}
+	return &headerTransport{base: transport, headers: headers, proxy: transport.Proxy, proxyAuth: proxyAuth}, nil
+}
+
+// headerTransport adds the extra headers of a profile, e.g. for an
+// authenticating reverse proxy, to every request
+type headerTransport struct {
+	base    http.RoundTripper
+	headers http.Header
+
+	// proxyAuth is sent with plain HTTP requests going through an HTTP proxy
+	proxy     func(*http.Request) (*url.URL, error)
+	proxyAuth string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not change the caller's request
+	req = req.Clone(req.Context())
+	for name, values := range t.headers {
+		// Headers set by FCLI itself, like Authorization, take precedence
+		if req.Header.Get(name) == "" {
+			req.Header[name] = values
+		}
+	}
+	if t.proxyAuth != "" && req.URL.Scheme == "http" && t.proxy != nil {
+		if proxyURL, err := t.proxy(req); err == nil && proxyURL != nil &&
+			(proxyURL.Scheme == "http" || proxyURL.Scheme == "https") {
+			req.Header.Set("Proxy-Authorization", t.proxyAuth)
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+func profileTLSConfig(p Profile) (*tls.Config, error) {
+	config := &tls.Config{}
+
+	if p.CAFile != "" {
+		pem, err := os.ReadFile(p.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %w", err)
+		}
+		// Trust the private CA on top of the system roots
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", p.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if p.ClientCert != "" || p.ClientKey != "" {
+		if p.ClientCert == "" || p.ClientKey == "" {
+			return nil, fmt.Errorf("both clientCert and clientKey are needed for a client certificate")
+		}
+		cert, err := tls.LoadX509KeyPair(p.ClientCert, p.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(p.PinnedCerts) > 0 {
+		pins := map[string]bool{}
+		for _, pin := range p.PinnedCerts {
+			pins[normalizeFingerprint(pin)] = true
+		}
+		// Runs after the normal chain verification, so pinning is an extra check
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return &PinError{}
+			}
+			for _, cert := range cs.PeerCertificates {
+				if pins[certFingerprint(cert)] {
+					return nil
+				}
+			}
+			return &PinError{Fingerprint: certFingerprint(cs.PeerCertificates[0])}
+		}
+	}
+	return config, nil
+}
+
+// certFingerprint is the SHA-256 of the certificate, as printed by
+// openssl x509 -noout -fingerprint -sha256 without the colons
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.TrimPrefix(strings.TrimSpace(fingerprint), "sha256:")
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
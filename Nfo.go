@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// NfoInfo covers the fields shared by Kodi movie, tvshow and episodedetails nfo files.
+// The root element is not checked so any of them can be decoded into it.
+type NfoInfo struct {
+	Title     string   `xml:"title"`
+	ShowTitle string   `xml:"showtitle"`
+	Plot      string   `xml:"plot"`
+	Outline   string   `xml:"outline"`
+	Year      string   `xml:"year"`
+	Premiered string   `xml:"premiered"`
+	Aired     string   `xml:"aired"`
+	Season    string   `xml:"season"`
+	Episode   string   `xml:"episode"`
+	Genre     []string `xml:"genre"`
+	Tag       []string `xml:"tag"`
+}
+
+// readNfo looks for a nfo file with the same name as the media file
+func readNfo(inputFile string) (NfoInfo, bool) {
+	var info NfoInfo
+	nfoPath := strings.TrimSuffix(inputFile, filepath.Ext(inputFile)) + ".nfo"
+	data, err := os.ReadFile(nfoPath)
+	if err != nil {
+		return info, false
+	}
+	// Kodi allows a scraper url after the xml, Unmarshal only reads the first element
+	if err := xml.Unmarshal(data, &info); err != nil {
+		fmt.Printf("Ignoring unreadable nfo file %s: %v\n", nfoPath, err)
+		return info, false
+	}
+	return info, true
+}
+
+func applyNfo(entry *MediaIndexEntry, info NfoInfo) {
+	if title := strings.TrimSpace(info.Title); title != "" {
+		entry.Title = title
+	}
+	if show := strings.TrimSpace(info.ShowTitle); show != "" {
+		entry.Series = show
+	}
+	if plot := strings.TrimSpace(info.Plot); plot != "" {
+		entry.Description = plot
+	} else if outline := strings.TrimSpace(info.Outline); outline != "" {
+		entry.Description = outline
+	}
+	for _, date := range []string{info.Year, info.Premiered, info.Aired} {
+		if year := yearFromDate(date); year != 0 {
+			entry.Year = year
+			break
+		}
+	}
+	if season, err := strconv.Atoi(strings.TrimSpace(info.Season)); err == nil && season >= 0 {
+		entry.Season = season
+	}
+	if episode, err := strconv.Atoi(strings.TrimSpace(info.Episode)); err == nil && episode > 0 {
+		entry.Episode = episode
+	}
+	if genres := trimAll(info.Genre); len(genres) > 0 {
+		entry.Genre = genres
+	}
+	if tags := trimAll(info.Tag); len(tags) > 0 {
+		entry.Tags = tags
+	}
+}
+
+// applyContainerTags fills in the title, description and year stored in the file itself
+func applyContainerTags(entry *MediaIndexEntry, tags map[string]string) {
+	tag := func(names ...string) string {
+		for _, name := range names {
+			for k, v := range tags {
+				if strings.EqualFold(k, name) && strings.TrimSpace(v) != "" {
+					return strings.TrimSpace(v)
+				}
+			}
+		}
+		return ""
+	}
+
+	if title := tag("title"); title != "" {
+		entry.Title = title
+	}
+	if show := tag("show", "series"); show != "" {
+		entry.Series = show
+	}
+	if description := tag("description", "synopsis", "comment"); description != "" {
+		entry.Description = description
+	}
+	if year := yearFromDate(tag("date", "date_released", "year")); year != 0 {
+		entry.Year = year
+	}
+}
+
+// yearFromDate accepts "2019", "2019-05-01" and similar
+func yearFromDate(date string) int {
+	date = strings.TrimSpace(date)
+	if len(date) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(date[:4])
+	if err != nil || year < 1800 {
+		return 0
+	}
+	return year
+}
+
+func trimAll(values []string) []string {
+	var out []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
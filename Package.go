@@ -0,0 +1,354 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// packageDirectory is the package setting for uploading the HLS output file
+// by file instead of as one archive
+const packageDirectory = "directory"
+
+// Packager turns the HLS output of a media file into a single file to upload
+type Packager interface {
+	Extension() string
+	// Write packs the files below dir, given as sorted slash separated
+	// relative paths, into target
+	Write(ctx context.Context, dir string, names []string, target string) error
+	// Verify reads target back and checks it holds exactly names
+	Verify(target string, names []string) error
+}
+
+var packagers = map[string]Packager{
+	"zip":     zipPackager{},
+	"tar":     tarPackager{},
+	"tar.zst": tarZstPackager{},
+}
+
+// packageEpoch is the time stamp of every entry, so the same files always give the same archive
+var packageEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ActivePackager returns the packager of the package setting, nil when the
+// output is uploaded as a directory
+func ActivePackager() (Packager, error) {
+	if AppConfig.Package == packageDirectory {
+		return nil, nil
+	}
+	name := AppConfig.Package
+	if name == "" {
+		name = "zip"
+	}
+	p, ok := packagers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown package %q, expected zip, tar, tar.zst or %s", AppConfig.Package, packageDirectory)
+	}
+	if _, ok := p.(tarZstPackager); ok {
+		if _, err := exec.LookPath("zstd"); err != nil {
+			return nil, fmt.Errorf("the tar.zst package needs zstd installed")
+		}
+	}
+	return p, nil
+}
+
+// PackageDirectory packs source into target. The archive is written under a
+// .part name and only renamed to target once it is complete and verified.
+func PackageDirectory(ctx context.Context, p Packager, source, target string) error {
+	names, err := listFiles(source)
+	if err != nil {
+		return err
+	}
+	partial := target + partialSuffix
+	err = p.Write(ctx, source, names, partial)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = p.Verify(partial, names)
+	}
+	if err != nil {
+		os.Remove(partial)
+		return err
+	}
+	return os.Rename(partial, target)
+}
+
+// packageExtension returns the extension of a file written by one of the packagers
+func packageExtension(name string) (string, bool) {
+	for _, p := range packagers {
+		if strings.HasSuffix(name, p.Extension()) {
+			return p.Extension(), true
+		}
+	}
+	return "", false
+}
+
+// packageStem is the name of a package without its extension
+func packageStem(name string) string {
+	ext, _ := packageExtension(name)
+	return strings.TrimSuffix(filepath.Base(name), ext)
+}
+
+// listFiles returns the files below dir as sorted slash separated relative paths
+func listFiles(dir string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(relPath))
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+// createSynced creates target, lets write fill it and makes sure it is on
+// disk before it gets renamed into place
+func createSynced(target string, write func(w io.Writer) error) error {
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type zipPackager struct{}
+
+func (zipPackager) Extension() string { return ".zip" }
+
+func (zipPackager) Write(ctx context.Context, dir string, names []string, target string) error {
+	method, err := zipMethod()
+	if err != nil {
+		return err
+	}
+	return createSynced(target, func(w io.Writer) error {
+		archive := zip.NewWriter(w)
+		for _, name := range names {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := addZipEntry(archive, dir, name, method); err != nil {
+				return fmt.Errorf("error adding %s: %w", name, err)
+			}
+		}
+		return archive.Close()
+	})
+}
+
+func addZipEntry(archive *zip.Writer, dir, name string, method uint16) error {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header := &zip.FileHeader{Name: name, Method: method, Modified: packageEpoch}
+	header.SetMode(0644)
+	w, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// zipMethod is the compression from the zipCompression setting. HLS segments
+// are already compressed, so storing them is as small and much faster.
+func zipMethod() (uint16, error) {
+	switch AppConfig.ZipCompression {
+	case "", "store":
+		return zip.Store, nil
+	case "deflate":
+		return zip.Deflate, nil
+	}
+	return 0, fmt.Errorf("unknown zipCompression %q, expected store or deflate", AppConfig.ZipCompression)
+}
+
+// Verify checks every entry against its CRC
+func (zipPackager) Verify(target string, names []string) error {
+	r, err := zip.OpenReader(target)
+	if err != nil {
+		return fmt.Errorf("error reopening zip: %w", err)
+	}
+	defer r.Close()
+	if len(r.File) != len(names) {
+		return fmt.Errorf("zip has %d entries, expected %d", len(r.File), len(names))
+	}
+	for i, entry := range r.File {
+		if entry.Name != names[i] {
+			return fmt.Errorf("zip entry %d is %s, expected %s", i, entry.Name, names[i])
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			return fmt.Errorf("error reading %s from zip: %w", entry.Name, err)
+		}
+		// archive/zip checks the CRC once the entry is read to the end
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("error verifying %s in zip: %w", entry.Name, err)
+		}
+	}
+	return nil
+}
+
+type tarPackager struct{}
+
+func (tarPackager) Extension() string { return ".tar" }
+
+func (tarPackager) Write(ctx context.Context, dir string, names []string, target string) error {
+	return createSynced(target, func(w io.Writer) error {
+		return writeTar(ctx, w, dir, names)
+	})
+}
+
+func (tarPackager) Verify(target string, names []string) error {
+	f, err := os.Open(target)
+	if err != nil {
+		return fmt.Errorf("error reopening tar: %w", err)
+	}
+	defer f.Close()
+	return verifyTar(f, names)
+}
+
+func writeTar(ctx context.Context, w io.Writer, dir string, names []string) error {
+	archive := tar.NewWriter(w)
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := addTarEntry(archive, dir, name); err != nil {
+			return fmt.Errorf("error adding %s: %w", name, err)
+		}
+	}
+	return archive.Close()
+}
+
+func addTarEntry(archive *tar.Writer, dir, name string) error {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     info.Size(),
+		ModTime:  packageEpoch,
+	}
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(archive, f)
+	return err
+}
+
+// verifyTar reads the whole archive, so a truncated one fails, and checks it
+// holds exactly names. Tar has no checksums for the content.
+func verifyTar(r io.Reader, names []string) error {
+	archive := tar.NewReader(r)
+	for i := 0; ; i++ {
+		header, err := archive.Next()
+		if err == io.EOF {
+			if i != len(names) {
+				return fmt.Errorf("tar has %d entries, expected %d", i, len(names))
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading tar: %w", err)
+		}
+		if i >= len(names) || header.Name != names[i] {
+			return fmt.Errorf("unexpected tar entry %s", header.Name)
+		}
+		if n, err := io.Copy(io.Discard, archive); err != nil || n != header.Size {
+			return fmt.Errorf("error verifying %s in tar: %v", header.Name, err)
+		}
+	}
+}
+
+// tarZstPackager pipes a tar through the zstd command
+type tarZstPackager struct{}
+
+func (tarZstPackager) Extension() string { return ".tar.zst" }
+
+func (tarZstPackager) Write(ctx context.Context, dir string, names []string, target string) error {
+	cmd := exec.CommandContext(ctx, "zstd", "-q", "-f", "-T0", "-o", target)
+	killProcessGroupOnCancel(cmd)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	var output strings.Builder
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting zstd: %w", err)
+	}
+	writeErr := writeTar(ctx, stdin, dir, names)
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("zstd failed: %w: %s", err, strings.TrimSpace(output.String()))
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	// zstd doesn't sync what it wrote
+	f, err := os.Open(target)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// Verify decompresses the archive, which also checks zstd's content checksum
+func (tarZstPackager) Verify(target string, names []string) error {
+	cmd := exec.Command("zstd", "-q", "-d", "-c", target)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var output strings.Builder
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting zstd: %w", err)
+	}
+	verifyErr := verifyTar(stdout, names)
+	// Drain the rest so zstd isn't stuck writing when the tar didn't match
+	io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("error decompressing %s: %w: %s", filepath.Base(target), err, strings.TrimSpace(output.String()))
+	}
+	return verifyErr
+}
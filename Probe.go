@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// MediaProbe holds the parts of the ffprobe json output that FCLI uses.
+type MediaProbe struct {
+	Format   ProbeFormat    `json:"format"`
+	Streams  []ProbeStream  `json:"streams"`
+	Chapters []ProbeChapter `json:"chapters"`
+}
+
+type ProbeFormat struct {
+	Duration string            `json:"duration"`
+	Tags     map[string]string `json:"tags"`
+}
+
+type ProbeChapter struct {
+	StartTime string            `json:"start_time"`
+	EndTime   string            `json:"end_time"`
+	Tags      map[string]string `json:"tags"`
+}
+
+type ProbeStream struct {
+	Index     int               `json:"index"`
+	CodecType string            `json:"codec_type"`
+	CodecName string            `json:"codec_name"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Tags      map[string]string `json:"tags"`
+}
+
+func ProbeMedia(inputFile string) (MediaProbe, error) {
+	var probe MediaProbe
+	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_format", "-show_streams", "-show_chapters", inputFile)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return probe, fmt.Errorf("error running ffprobe: %w; output: %s", err, stderr.String())
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &probe); err != nil {
+		return probe, fmt.Errorf("error parsing ffprobe output: %w", err)
+	}
+	return probe, nil
+}
+
+// DurationSeconds returns the container duration rounded to whole seconds.
+func (p MediaProbe) DurationSeconds() int {
+	d, err := strconv.ParseFloat(p.Format.Duration, 64)
+	if err != nil {
+		return 0
+	}
+	return int(d + 0.5)
+}
+
+func (p MediaProbe) firstStream(codecType string) (ProbeStream, bool) {
+	for _, s := range p.Streams {
+		if s.CodecType == codecType {
+			return s, true
+		}
+	}
+	return ProbeStream{}, false
+}
+
+// Resolution returns WIDTHxHEIGHT of the first video stream, or "" for audio only files.
+func (p MediaProbe) Resolution() string {
+	s, ok := p.firstStream("video")
+	if !ok || s.Width == 0 || s.Height == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d", s.Width, s.Height)
+}
+
+// Language returns the language tag of an audio stream. audioTrack counts
+// only the audio streams, like the 0:a:N the track is transcoded with.
+func (p MediaProbe) Language(audioTrack int) string {
+	var s ProbeStream
+	found := false
+	for _, stream := range p.Streams {
+		if stream.CodecType != "audio" {
+			continue
+		}
+		if audioTrack == 0 {
+			s, found = stream, true
+			break
+		}
+		audioTrack--
+	}
+	if !found {
+		return ""
+	}
+	lang := s.Tags["language"]
+	if lang == "und" {
+		return ""
+	}
+	return lang
+}
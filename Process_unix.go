@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// stopSignals cancel the run like Ctrl-C. ffmpeg runs in its own process
+// group and doesn't get the signals of the terminal, SIGHUP is when it closes.
+var stopSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP}
+
+// killProcessGroupOnCancel starts cmd in its own process group, so Ctrl-C
+// reaches only FCLI, and kills the whole group when cmd's context is cancelled
+func killProcessGroupOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const profilesFileName = "profiles.json"
+
+// Profile is a saved connection to a Farnsworth instance
+type Profile struct {
+	URL         string `json:"url"`
+	Username    string `json:"username"`
+	PasswordRef string `json:"passwordRef"` // see StoreSecret, "none" when the password isn't saved
+
+	// TLS settings for instances behind a private CA or requiring client certificates
+	CAFile      string   `json:"caFile,omitempty"`
+	ClientCert  string   `json:"clientCert,omitempty"`
+	ClientKey   string   `json:"clientKey,omitempty"`
+	PinnedCerts []string `json:"pinnedCerts,omitempty"` // SHA-256 certificate fingerprints
+
+	// For instances behind gateways. Proxy is an http(s) or socks5 url, empty to
+	// use the environment or "none" to connect directly.
+	Proxy   string            `json:"proxy,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+type ProfileStore struct {
+	Default  string             `json:"default"`
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+// ProfileName is the profile in use, set with --profile or picked at login
+var ProfileName string
+
+// CurrentProfile holds the connection settings in use, including unsaved ones
+var CurrentProfile Profile
+
+func (p Profile) Account() string {
+	return p.Username + "@" + p.URL
+}
+
+func profilesPath() string {
+	return filepath.Join(ConfigDir(), profilesFileName)
+}
+
+func LoadProfiles() (ProfileStore, error) {
+	store := ProfileStore{Profiles: map[string]Profile{}}
+	data, err := os.ReadFile(profilesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+		return store, err
+	}
+	if err := json.Unmarshal(data, &store); err != nil {
+		return store, fmt.Errorf("error parsing %s: %w", profilesPath(), err)
+	}
+	if store.Profiles == nil {
+		store.Profiles = map[string]Profile{}
+	}
+	return store, nil
+}
+
+func (s ProfileStore) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(profilesPath(), data, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(profilesPath(), 0600)
+}
+
+func (s ProfileStore) Names() []string {
+	names := make([]string, 0, len(s.Profiles))
+	for name := range s.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UseProfile makes the profile the current connection
+func UseProfile(name string, p Profile) {
+	ProfileName = name
+	CurrentProfile = p
+	API_BASE_URL = p.URL
+	Username = p.Username
+	Password = ""
+	passwordRef = p.PasswordRef
+}
+
+// HandleProfileCommand implements fcli profile list/add/remove/use/set
+func HandleProfileCommand(r *bufio.Reader, args []string) {
+	store, err := LoadProfiles()
+	if err != nil {
+		fmt.Println("Error loading profiles:", err)
+		return
+	}
+	importLegacyConnectionFiles(r, &store)
+
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	switch args[0] {
+	case "list", "ls":
+		if len(store.Profiles) == 0 {
+			fmt.Println("No saved profiles. Add one with: fcli profile add [name]")
+			return
+		}
+		for _, name := range store.Names() {
+			p := store.Profiles[name]
+			marker := " "
+			if name == store.Default {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\t%s@%s\n", marker, name, p.Username, p.URL)
+		}
+	case "add":
+		name := ""
+		if len(args) > 1 {
+			name = args[1]
+		}
+		API_BASE_URL = GetInputWithPrompt(r, "What is the URL of the instance you are connecting to?", "http://localhost:8080")
+		Username = GetInputWithPrompt(r, "What is the username for the instance?")
+		Password = ""
+		SaveConnectionInfo(r, &store, name)
+	case "remove", "rm":
+		if len(args) < 2 {
+			fmt.Println("Usage: fcli profile remove <name>")
+			return
+		}
+		p, ok := store.Profiles[args[1]]
+		if !ok {
+			fmt.Printf("No profile named %s\n", args[1])
+			return
+		}
+		if err := DeleteSecret(p.Account(), p.PasswordRef); err != nil {
+			fmt.Printf("Error removing the saved password: %v\n", err)
+		}
+		delete(store.Profiles, args[1])
+		if store.Default == args[1] {
+			store.Default = ""
+		}
+		if err := store.Save(); err != nil {
+			fmt.Println("Error saving profiles:", err)
+			return
+		}
+		fmt.Printf("Removed profile %s\n", args[1])
+	case "use":
+		if len(args) < 2 {
+			fmt.Println("Usage: fcli profile use <name>")
+			return
+		}
+		if _, ok := store.Profiles[args[1]]; !ok {
+			fmt.Printf("No profile named %s\n", args[1])
+			return
+		}
+		store.Default = args[1]
+		if err := store.Save(); err != nil {
+			fmt.Println("Error saving profiles:", err)
+			return
+		}
+		fmt.Printf("%s is now the default profile\n", args[1])
+	case "set":
+		if len(args) != 4 {
+			fmt.Println("Usage: fcli profile set <name> <key> <value>")
+			fmt.Println("Keys: url, username, caFile, clientCert, clientKey, pinnedCerts (comma separated, empty to clear),")
+			fmt.Println("      proxy (url or none), header (\"Name: value\", empty value removes it), headers (empty to clear)")
+			return
+		}
+		p, ok := store.Profiles[args[1]]
+		if !ok {
+			fmt.Printf("No profile named %s\n", args[1])
+			return
+		}
+		old := p
+		if err := p.Set(args[2], args[3]); err != nil {
+			fmt.Println(err)
+			return
+		}
+		// The saved password is found by user and url, it has to move along
+		if err := MoveSecret(old.Account(), p.Account(), p.PasswordRef); err != nil {
+			fmt.Printf("Error moving the saved password: %v\n", err)
+			fmt.Printf("Remove the profile and add it again with fcli profile add to change its %s\n", args[2])
+			return
+		}
+		store.Profiles[args[1]] = p
+		if err := store.Save(); err != nil {
+			fmt.Println("Error saving profiles:", err)
+			return
+		}
+		fmt.Printf("Updated %s of profile %s\n", args[2], args[1])
+		if _, err := NewTransport(p); err != nil {
+			fmt.Println("Warning, the profile can not connect like this yet:", err)
+		}
+	default:
+		fmt.Printf("Unknown profile command %s\n", args[0])
+		fmt.Print(PrintUsage())
+	}
+}
+
+// SaveConnectionInfo stores the current connection as a profile, asking for a
+// name when none is given
+func SaveConnectionInfo(r *bufio.Reader, store *ProfileStore, name string) bool {
+	if name == "" {
+		// Remove http:// and https:// from the hostName
+		hostName := strings.TrimPrefix(API_BASE_URL, "http://")
+		hostName = strings.TrimPrefix(hostName, "https://")
+		name = GetInputWithPrompt(r, "What should this profile be called?", hostName)
+	}
+	if _, exists := store.Profiles[name]; exists {
+		resp := strings.ToLower(GetInputWithPrompt(r, fmt.Sprintf("A profile named %s already exists, replace it? y/n", name), "n"))
+		if resp != "y" && resp != "yes" {
+			return false
+		}
+	}
+
+	resp := strings.ToLower(GetInputWithPrompt(r, "Do you also want to save the password? The login session is kept either way, do not do this on shared computers. y/n", "n"))
+	ref := noSecretRef
+	if resp == "y" || resp == "yes" {
+		ensurePassword(r)
+		var err error
+		ref, err = StoreSecret(r, credentialAccount(), Password)
+		if err != nil {
+			fmt.Printf("Error saving password: %v\n", err)
+			return false
+		}
+	}
+	passwordRef = ref
+
+	// Replacing a profile keeps its connection settings
+	p := store.Profiles[name]
+	p.URL = API_BASE_URL
+	p.Username = Username
+	p.PasswordRef = ref
+	store.Profiles[name] = p
+	CurrentProfile = p
+	if store.Default == "" {
+		store.Default = name
+	}
+	ProfileName = name
+	if err := store.Save(); err != nil {
+		fmt.Printf("Error writing profiles: %v\n", err)
+		return false
+	}
+	fmt.Printf("Saved profile %s\n", name)
+	return true
+}
+
+// Set changes one of the settings that can't be given during login
+func (p *Profile) Set(key, value string) error {
+	switch key {
+	case "url":
+		p.URL = value
+	case "username":
+		p.Username = value
+	case "caFile":
+		p.CAFile = absPath(value)
+	case "clientCert":
+		p.ClientCert = absPath(value)
+	case "clientKey":
+		p.ClientKey = absPath(value)
+	case "pinnedCerts":
+		p.PinnedCerts = nil
+		for _, pin := range strings.Split(value, ",") {
+			if pin = normalizeFingerprint(pin); pin != "" {
+				p.PinnedCerts = append(p.PinnedCerts, pin)
+			}
+		}
+	case "proxy":
+		p.Proxy = value
+	case "header":
+		// "Name: value" adds or replaces a header, "Name:" removes it
+		name, headerValue, ok := strings.Cut(value, ":")
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if !ok || name == "" {
+			return fmt.Errorf("expected a header like \"X-Api-Key: value\"")
+		}
+		if p.Headers == nil {
+			p.Headers = map[string]string{}
+		}
+		if headerValue = strings.TrimSpace(headerValue); headerValue == "" {
+			delete(p.Headers, name)
+		} else {
+			p.Headers[name] = headerValue
+		}
+	case "headers":
+		if value != "" {
+			return fmt.Errorf("headers can only be cleared, use header to add one")
+		}
+		p.Headers = nil
+	default:
+		return fmt.Errorf("unknown profile setting %s", key)
+	}
+	return nil
+}
+
+// absPath keeps file settings working when fcli is run from another folder
+func absPath(name string) string {
+	if name == "" {
+		return ""
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return name
+	}
+	return abs
+}
+
+// importLegacyConnectionFiles turns the <host>.fn files older versions wrote
+// next to the executable or into the working directory into profiles.
+// Base64 passwords are moved to secure storage on the way.
+func importLegacyConnectionFiles(r *bufio.Reader, store *ProfileStore) {
+	var dirs []string
+	if execPath, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Dir(execPath))
+	}
+	if wd, err := os.Getwd(); err == nil && (len(dirs) == 0 || wd != dirs[0]) {
+		dirs = append(dirs, wd)
+	}
+
+	imported := false
+	for _, dir := range dirs {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if file.IsDir() || !strings.HasSuffix(file.Name(), ".fn") {
+				continue
+			}
+			credsPath := filepath.Join(dir, file.Name())
+			name := strings.TrimSuffix(file.Name(), ".fn")
+			if _, exists := store.Profiles[name]; exists {
+				continue
+			}
+			p, err := readLegacyConnectionFile(r, credsPath)
+			if err != nil {
+				fmt.Printf("Could not import %s: %v\n", credsPath, err)
+				continue
+			}
+			store.Profiles[name] = p
+			if store.Default == "" {
+				store.Default = name
+			}
+			imported = true
+			fmt.Printf("Imported %s as profile %s\n", credsPath, name)
+			if err := os.Remove(credsPath); err != nil {
+				fmt.Printf("Error removing %s: %v\n", credsPath, err)
+			}
+		}
+	}
+	if imported {
+		if err := store.Save(); err != nil {
+			fmt.Println("Error saving profiles:", err)
+		}
+	}
+}
+
+// readLegacyConnectionFile reads the instance url followed by either a base64
+// "user:pass" line or the username and a secret reference
+func readLegacyConnectionFile(r *bufio.Reader, credsPath string) (Profile, error) {
+	data, err := os.ReadFile(credsPath)
+	if err != nil {
+		return Profile{}, err
+	}
+	lines := strings.Split(strings.TrimRight(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n"), "\n")
+	if len(lines) < 2 {
+		return Profile{}, fmt.Errorf("creds file corrupted or incorrect")
+	}
+	if len(lines) >= 3 && lines[2] != "" {
+		return Profile{URL: lines[0], Username: lines[1], PasswordRef: lines[2]}, nil
+	}
+
+	c, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil {
+		return Profile{}, fmt.Errorf("creds file corrupted or incorrect")
+	}
+	username, password, ok := strings.Cut(string(c), ":")
+	if !ok {
+		return Profile{}, fmt.Errorf("creds file corrupted or incorrect")
+	}
+	p := Profile{URL: lines[0], Username: username}
+	fmt.Printf("%s stores the password unencrypted, moving it to secure storage\n", filepath.Base(credsPath))
+	p.PasswordRef, err = StoreSecret(r, p.Account(), password)
+	if err != nil {
+		return Profile{}, err
+	}
+	return p, nil
+}
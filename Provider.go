@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const catalogFileName = "catalog.json"
+
+// MetadataQuery is what is known about a file before asking a provider.
+type MetadataQuery struct {
+	Title   string
+	Series  string
+	Year    int
+	Season  int
+	Episode int
+}
+
+// MetadataProvider looks up extra metadata for a file. Lookup returns false when
+// the provider has nothing for the query. Only empty fields of the entry are filled
+// from the result, so values typed in or found in the file always win.
+type MetadataProvider interface {
+	Name() string
+	Lookup(query MetadataQuery) (MediaIndexEntry, bool, error)
+}
+
+var metadataProviders []MetadataProvider
+
+func init() {
+	RegisterMetadataProvider(&CatalogProvider{})
+}
+
+func RegisterMetadataProvider(provider MetadataProvider) {
+	metadataProviders = append(metadataProviders, provider)
+}
+
+// EnrichMetaData asks each provider in turn and merges the first match into entry
+func EnrichMetaData(entry *MediaIndexEntry, query MetadataQuery) {
+	for _, provider := range metadataProviders {
+		found, ok, err := provider.Lookup(query)
+		if err != nil {
+			log.Printf("Error looking up %q with %s: %v", query.Title, provider.Name(), err)
+			continue
+		}
+		if ok {
+			mergeMissing(entry, found)
+			return
+		}
+	}
+}
+
+func mergeMissing(entry *MediaIndexEntry, found MediaIndexEntry) {
+	if entry.Title == "" {
+		entry.Title = found.Title
+	}
+	if entry.Description == "" {
+		entry.Description = found.Description
+	}
+	if len(entry.Genre) == 0 {
+		entry.Genre = found.Genre
+	}
+	if len(entry.Tags) == 0 {
+		entry.Tags = found.Tags
+	}
+	if entry.Series == "" {
+		entry.Series = found.Series
+	}
+	if entry.Season == 0 {
+		entry.Season = found.Season
+	}
+	if entry.Episode == 0 {
+		entry.Episode = found.Episode
+	}
+	if entry.Year == 0 {
+		entry.Year = found.Year
+	}
+	if entry.Language == "" {
+		entry.Language = found.Language
+	}
+}
+
+// CatalogProvider reads catalog.json, a json array of media entries, from the
+// folder being processed or from next to the executable.
+type CatalogProvider struct {
+	mu      sync.Mutex
+	path    string
+	entries []MediaIndexEntry
+}
+
+func (c *CatalogProvider) Name() string {
+	return "local catalog"
+}
+
+func (c *CatalogProvider) Lookup(query MetadataQuery) (MediaIndexEntry, bool, error) {
+	entries, err := c.load()
+	if err != nil {
+		return MediaIndexEntry{}, false, err
+	}
+
+	if query.Series != "" {
+		var show MediaIndexEntry
+		foundShow := false
+		for _, e := range entries {
+			if !sameName(e.Series, query.Series) {
+				continue
+			}
+			if e.Season == query.Season && e.Episode == query.Episode {
+				return e, true, nil
+			}
+			// An entry without an episode describes the whole series
+			if e.Episode == 0 && !foundShow {
+				show = e
+				foundShow = true
+			}
+		}
+		if foundShow {
+			show.Title = ""
+			return show, true, nil
+		}
+		return MediaIndexEntry{}, false, nil
+	}
+
+	for _, e := range entries {
+		if sameName(e.Title, query.Title) && (query.Year == 0 || e.Year == 0 || e.Year == query.Year) {
+			return e, true, nil
+		}
+	}
+	return MediaIndexEntry{}, false, nil
+}
+
+func (c *CatalogProvider) load() ([]MediaIndexEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	catalogPath := findCatalog()
+	if catalogPath == c.path {
+		return c.entries, nil
+	}
+	c.path = catalogPath
+	c.entries = nil
+	if catalogPath == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(catalogPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading catalog %s: %w", catalogPath, err)
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		c.entries = nil
+		return nil, fmt.Errorf("error parsing catalog %s: %w", catalogPath, err)
+	}
+	return c.entries, nil
+}
+
+func findCatalog() string {
+	candidates := []string{filepath.Join(cwd, catalogFileName)}
+	if execPath, err := os.Executable(); err == nil {
+		candidates = append(candidates, filepath.Join(filepath.Dir(execPath), catalogFileName))
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+func sameName(a, b string) bool {
+	return a != "" && strings.EqualFold(cleanMediaName(a), cleanMediaName(b))
+}
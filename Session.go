@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const sessionsFileName = "sessions.json"
+
+// Session is a cached auth-token for one user on one instance
+type Session struct {
+	URL      string    `json:"url"`
+	Username string    `json:"username"`
+	Token    string    `json:"token"`
+	Expires  time.Time `json:"expires"` // zero when the server did not send an expiry
+}
+
+// sessionMu guards Token once uploads run, RefreshSession holds it while logging in again
+var sessionMu sync.Mutex
+
+// CurrentToken returns the session token, safe to call while another upload refreshes it
+func CurrentToken() string {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	return Token
+}
+
+// CachedSession returns the saved session for the current connection if it
+// hasn't expired. Whether the server still accepts it is only known once it is used.
+func CachedSession() (Session, bool) {
+	sessions, err := loadSessions()
+	if err != nil {
+		log.Printf("Error reading saved sessions: %v", err)
+		return Session{}, false
+	}
+	session, ok := sessions[credentialAccount()]
+	if !ok || session.Token == "" {
+		return Session{}, false
+	}
+	if !session.Expires.IsZero() && time.Now().After(session.Expires) {
+		return Session{}, false
+	}
+	return session, true
+}
+
+func SaveSession(token string, expires time.Time) error {
+	sessions, err := loadSessions()
+	if err != nil {
+		sessions = map[string]Session{}
+	}
+	sessions[credentialAccount()] = Session{
+		URL:      API_BASE_URL,
+		Username: Username,
+		Token:    token,
+		Expires:  expires,
+	}
+	return saveSessions(sessions)
+}
+
+// RefreshSession logs in again after the server rejected staleToken. Uploads
+// running in parallel that hit the same rejection share a single login.
+func RefreshSession(r *bufio.Reader, staleToken string) (string, bool) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	if Token != staleToken {
+		return Token, true
+	}
+	fmt.Println("Login session was rejected, logging in again")
+	if !Authenticate(r) {
+		return "", false
+	}
+	return Token, true
+}
+
+// HandleLogout revokes and forgets saved sessions. With no arguments every
+// session is removed, otherwise only the ones matching the given url or user@url.
+func HandleLogout(args []string) {
+	sessions, err := loadSessions()
+	if err != nil {
+		fmt.Println("Error reading saved sessions:", err)
+		return
+	}
+
+	removed := 0
+	for key, session := range sessions {
+		if len(args) > 0 && args[0] != key && strings.TrimSuffix(args[0], "/") != strings.TrimSuffix(session.URL, "/") {
+			continue
+		}
+		revokeSession(session)
+		delete(sessions, key)
+		fmt.Printf("Logged out %s\n", key)
+		removed++
+	}
+	if removed == 0 {
+		fmt.Println("No saved sessions to log out")
+		return
+	}
+	if err := saveSessions(sessions); err != nil {
+		fmt.Println("Error saving sessions:", err)
+	}
+}
+
+// revokeSession tells the server to drop the token. Servers without a logout
+// endpoint are fine, the token is forgotten locally either way.
+func revokeSession(session Session) {
+	req, err := http.NewRequest("POST", fmt.Sprintf("%v/logout/", session.URL), nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+session.Token)
+	client := &http.Client{
+		Timeout: time.Second * 30,
+	}
+	// Use the TLS settings of the matching profile, if there still is one
+	if store, err := LoadProfiles(); err == nil {
+		for _, p := range store.Profiles {
+			if p.URL == session.URL && p.Username == session.Username {
+				if transport, err := NewTransport(p); err == nil {
+					client.Transport = transport
+				}
+				break
+			}
+		}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		fmt.Printf("Could not reach %s to revoke the session: %v\n", session.URL, err)
+		return
+	}
+	res.Body.Close()
+}
+
+func sessionsPath() string {
+	return filepath.Join(ConfigDir(), sessionsFileName)
+}
+
+func loadSessions() (map[string]Session, error) {
+	sessions := map[string]Session{}
+	data, err := os.ReadFile(sessionsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return sessions, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func saveSessions(sessions map[string]Session) error {
+	data, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(sessionsPath(), data, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(sessionsPath(), 0600)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const stateFileName = ".fcli-state.json"
+
+// partialSuffix marks files that are still being written, they are renamed
+// once complete so a file without it is never half written
+const partialSuffix = ".part"
+
+// Stages a file goes through, in order
+const (
+	stageProbed     = "probed"
+	stageTranscoded = "transcoded"
+	stageZipped     = "zipped" // packaged, or the finished directory when uploading it as is
+	stageUploaded   = "uploaded"
+
+	// stageFailed is a file that could not be transcoded or packaged
+	stageFailed = "failed"
+)
+
+// FileState is how far one media file of a folder got
+type FileState struct {
+	Stage          string    `json:"stage"`
+	Source         string    `json:"source,omitempty"` // media file, empty for zips FCLI didn't make
+	Fingerprint    string    `json:"fingerprint,omitempty"`
+	Subtitle       string    `json:"subtitle,omitempty"`
+	AudioTrack     string    `json:"audioTrack,omitempty"`
+	OutputDir      string    `json:"outputDir,omitempty"`
+	Zip            string    `json:"zip,omitempty"`
+	ZipSHA256      string    `json:"zipSha256,omitempty"`
+	ChunkSize      int64     `json:"chunkSize,omitempty"`
+	UploadedChunks int64     `json:"uploadedChunks,omitempty"`
+	URL            string    `json:"url,omitempty"`
+	Updated        time.Time `json:"updated"`
+}
+
+// PipelineState is kept in .fcli-state.json in the media folder so a run that
+// was interrupted can carry on where it stopped
+type PipelineState struct {
+	Files map[string]*FileState `json:"files"` // keyed by media file name, or zip name without a source
+
+	dir string
+	mu  sync.Mutex
+}
+
+// pipeline is the state of the folder being processed
+var pipeline *PipelineState
+
+// LoadPipelineState reads the state file of dir, an unreadable file starts over
+func LoadPipelineState(dir string) *PipelineState {
+	state := &PipelineState{Files: map[string]*FileState{}, dir: dir}
+	data, err := os.ReadFile(filepath.Join(dir, stateFileName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading %s: %v", stateFileName, err)
+		}
+		return state
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		log.Printf("Error parsing %s, starting over: %v", stateFileName, err)
+		state.Files = map[string]*FileState{}
+	}
+	if state.Files == nil {
+		state.Files = map[string]*FileState{}
+	}
+	return state
+}
+
+// Update changes the state of a file and saves the state file right away
+func (s *PipelineState) Update(key string, change func(f *FileState)) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.Files[key]
+	if !ok {
+		f = &FileState{}
+		s.Files[key] = f
+	}
+	change(f)
+	f.Updated = time.Now()
+	if err := s.save(); err != nil {
+		log.Printf("Error saving %s: %v", stateFileName, err)
+	}
+}
+
+// Get returns a copy of the state of a file
+func (s *PipelineState) Get(key string) (FileState, bool) {
+	if s == nil {
+		return FileState{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.Files[key]
+	if !ok {
+		return FileState{}, false
+	}
+	return *f, true
+}
+
+// KeyForZip finds the file a zip belongs to, zips FCLI doesn't know about yet
+// are tracked under their own name
+func (s *PipelineState) KeyForZip(zipFile string) string {
+	if s != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for key, f := range s.Files {
+			if f.Zip == zipFile {
+				return key
+			}
+		}
+	}
+	return filepath.Base(zipFile)
+}
+
+// Tracks reports whether the zip was made or uploaded by an earlier run
+func (s *PipelineState) Tracks(zipFile string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.Files {
+		if f.Zip == zipFile {
+			return true
+		}
+	}
+	return false
+}
+
+// Resumable returns the zips that were finished but not uploaded and the
+// media files whose processing was interrupted
+func (s *PipelineState) Resumable() (zips []string, pending []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, f := range s.Files {
+		switch f.Stage {
+		case stageZipped:
+			if packageExists(f.Zip) {
+				zips = append(zips, f.Zip)
+			} else if f.Source != "" {
+				pending = append(pending, key)
+			}
+		case stageProbed, stageTranscoded:
+			if f.Source != "" {
+				pending = append(pending, key)
+			}
+		}
+	}
+	sort.Strings(zips)
+	sort.Strings(pending)
+	return zips, pending
+}
+
+// save writes the state file atomically, so a crash leaves the old or the new one
+func (s *PipelineState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, stateFileName), data, 0644)
+}
+
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	tmp := name + partialSuffix
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// packageExists reports whether a finished package, or output directory, is still there
+func packageExists(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && (info.IsDir() || info.Size() > 0)
+}
+
+// removePartialFiles deletes the packages and state an interrupted run was still writing
+func removePartialFiles(dir string) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		complete := strings.TrimSuffix(file.Name(), partialSuffix)
+		// Only files FCLI writes, a movie.mkv.part from a download is left alone
+		_, isPackage := packageExtension(complete)
+		if !file.IsDir() && complete != file.Name() && (isPackage || complete == stateFileName) {
+			name := filepath.Join(dir, file.Name())
+			if err := os.Remove(name); err != nil {
+				log.Printf("Error removing partial file %s: %v", name, err)
+			} else {
+				fmt.Printf("Removed partial file %s\n", file.Name())
+			}
+		}
+	}
+}
@@ -1,13 +1,12 @@
 package main
 
 import (
-	"archive/zip"
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/vbauerster/mpb/v8"
 	"github.com/vbauerster/mpb/v8/decor"
-	"io"
 	"log"
 	"os"
 	"os/exec"
@@ -19,9 +18,8 @@ import (
 )
 
 var cwd string
-var UseHardwareAccel bool
 
-func HandleTranscoding(r *bufio.Reader) ([]string, bool) {
+func HandleTranscoding(ctx context.Context, r *bufio.Reader) ([]string, bool) {
 	cwd, _ = os.Getwd()
 	fmt.Print(PrintHeader())
 	fmt.Printf("%v:>", cwd)
@@ -29,122 +27,187 @@ func HandleTranscoding(r *bufio.Reader) ([]string, bool) {
 		fmt.Printf("%v:>", cwd)
 	}
 
+	pipeline = LoadPipelineState(cwd)
+	removePartialFiles(cwd)
+	resumedZips, pending := pipeline.Resumable()
+	if len(resumedZips) > 0 || len(pending) > 0 {
+		fmt.Println("Resuming the interrupted run in this folder:")
+		for _, zipFile := range resumedZips {
+			fmt.Printf("\tready to upload: %s\n", filepath.Base(zipFile))
+		}
+		for _, source := range pending {
+			fmt.Printf("\tto transcode again: %s\n", source)
+		}
+	}
+
+	// Archives an earlier run knows about are resumed above, only ask about other ones
 	var zipFiles []string
 	files, err := os.ReadDir(cwd)
 	if checkError(err) {
 		for _, file := range files {
-			if !file.IsDir() && strings.HasSuffix(file.Name(), ".zip") {
-				zipFiles = append(zipFiles, path.Join(cwd, file.Name()))
+			zipFile := path.Join(cwd, file.Name())
+			_, isPackage := packageExtension(file.Name())
+			if !file.IsDir() && isPackage && !pipeline.Tracks(zipFile) {
+				zipFiles = append(zipFiles, zipFile)
 			}
 		}
 	}
 
 	if len(zipFiles) > 0 {
-		fmt.Println("Existing zip files found:")
+		fmt.Println("Existing archives found:")
 		for _, zipFile := range zipFiles {
 			fmt.Println(zipFile)
 		}
-		choice := GetInputWithPrompt(r, "Do you want to use these zip files instead of re-transcoding? (y/n): ")
+		choice := GetInputWithPrompt(r, "Do you want to use these archives instead of re-transcoding? (y/n): ")
 		if choice == "y" || choice == "Y" {
-			return zipFiles, true
+			return append(resumedZips, zipFiles...), true
 		}
 	}
+	zipFiles = resumedZips
+	resumed := len(zipFiles)
+
+	packager, err := ActivePackager()
+	if err != nil {
+		fmt.Println(err)
+		return nil, false
+	}
 
 	p := mpb.New()
-	var wg sync.WaitGroup
+	profile := AppConfig.ActiveEncodeProfile()
 
 	var selections map[os.DirEntry][]string
 	selections = make(map[os.DirEntry][]string, len(files))
+	hasVideo := false
 	for _, tf := range files {
 		if !tf.IsDir() && isMediaFile(tf.Name()) {
+			zipFileName := packagePathFor(tf.Name(), packager)
+			state, known := pipeline.Get(tf.Name())
+			if known && state.Stage == stageUploaded {
+				fmt.Printf("Skipping %s, it was uploaded already (delete %s to start over)\n", tf.Name(), stateFileName)
+				continue
+			}
+			if known && state.Stage == stageZipped && packageExists(state.Zip) {
+				continue
+			}
+
+			entry := DescribeSource(path.Join(cwd, tf.Name()), state.AudioTrack)
+			if known && state.Stage == stageFailed {
+				fmt.Printf("%s failed to transcode on the last run, trying again\n", tf.Name())
+			} else if known && state.Source != "" {
+				// Interrupted earlier, carry on with the tracks chosen then
+				if state.OutputDir != "" {
+					os.RemoveAll(state.OutputDir)
+				}
+				selections[tf] = []string{state.Subtitle, state.AudioTrack}
+				recordSourceEntry(zipFileName, entry)
+				if entry.Resolution != "" {
+					hasVideo = true
+				}
+				continue
+			}
+			// Check before spending time on transcoding, the directory is only
+			// known for sure once the metadata is entered
+			check := entry
+			if check.Title == "" {
+				check.Title = strings.TrimSuffix(tf.Name(), filepath.Ext(tf.Name()))
+			}
+			check.Directory = AppConfig.DefaultDirectory
+			if SkipDuplicate(r, tf.Name(), check, "") {
+				continue
+			}
+
 			//only ask about the first set of
 			subtitle := selectSubtitleTrack(r, tf.Name())
 			audioTrack := selectAudioTrack(r, tf.Name())
 			selections[tf] = make([]string, 2)
 			selections[tf][0] = subtitle
 			selections[tf][1] = audioTrack
+			if audioTrackIndex(audioTrack) != audioTrackIndex(state.AudioTrack) {
+				// The entry was described before this track was picked
+				entry.Language = AudioTrackLanguage(path.Join(cwd, tf.Name()), audioTrack)
+			}
+			recordSourceEntry(zipFileName, entry)
+			pipeline.Update(tf.Name(), func(f *FileState) {
+				f.Stage = stageProbed
+				f.Source = path.Join(cwd, tf.Name())
+				f.Fingerprint = entry.Fingerprint
+				f.Subtitle = subtitle
+				f.AudioTrack = audioTrack
+				f.OutputDir = outputDirFor(tf.Name())
+				f.Zip = zipFileName
+			})
+			if entry.Resolution != "" {
+				hasVideo = true
+			}
 		}
 	}
 
-	for _, file := range files {
-		if !file.IsDir() && isMediaFile(file.Name()) {
-			inputFile := path.Join(cwd, file.Name())
-			fn := file.Name()
-			outputDir := filepath.Join(cwd, strings.TrimSuffix(fn, filepath.Ext(fn))) // Correct outputDir
-			err := os.MkdirAll(outputDir, os.ModePerm)
-			if err != nil {
-				log.Fatal(err)
-			}
-			subtitle := selections[file][0]
-			audioTrack := selections[file][1]
-
-			if UseMulti {
-				wg.Add(1)
-				go func(inputFile, outputFile, subtitle, audioTrack, fn string) {
-					defer wg.Done()
-					fileBar := p.AddSpinner(1,
-						mpb.PrependDecorators(
-							decor.Name(fmt.Sprintf("Processing %s: ", fn)),
-						),
-					)
-					err := TranscodeToHLSWithSubtitle(inputFile, outputDir, subtitle, audioTrack)
-					if err != nil {
-						log.Printf("Error transcoding file %s: %v", path.Base(inputFile), err)
-					}
-
-					zipFileName := outputDir + ".zip"
-					err = ZipDirectory(outputDir, zipFileName)
-					if err != nil {
-						log.Printf("Error zipping directory %s: %v", outputDir, err)
-					} else {
-						zipFiles = append(zipFiles, zipFileName)
-					}
-
-					err = os.RemoveAll(outputDir)
-					if err != nil {
-						log.Printf("Error deleting directory %s: %v", outputDir, err)
-					}
-
-					fileBar.Increment()
-				}(inputFile, outputDir, subtitle, audioTrack, fn)
-			} else {
-				fileBar := p.AddSpinner(1,
-					mpb.PrependDecorators(
-						decor.Name(fmt.Sprintf("Processing %s: ", fn)),
-					),
-				)
-				err := TranscodeToHLSWithSubtitle(inputFile, outputDir, subtitle, audioTrack)
-				if err != nil {
-					log.Printf("Error transcoding file %s: %v", file.Name(), err)
-				}
-
-				zipFileName := outputDir + ".zip"
-				err = ZipDirectory(outputDir, zipFileName)
-				if err != nil {
-					log.Printf("Error zipping directory %s: %v", outputDir, err)
-				} else {
-					zipFiles = append(zipFiles, zipFileName)
-				}
-
-				err = os.RemoveAll(outputDir)
-				if err != nil {
-					log.Printf("Error deleting directory %s: %v", outputDir, err)
-				}
+	if hasVideo {
+		AppConfig.PosterTimestamp = GetInputWithPrompt(r, "Enter the poster frame timestamp, e.g. 00:05:00 (leave blank to pick a scene automatically):", AppConfig.PosterTimestamp)
+	}
 
-				fileBar.Increment()
-			}
+	var jobs []transcodeJob
+	for _, file := range files {
+		if selection, selected := selections[file]; selected {
+			jobs = append(jobs, transcodeJob{
+				index:      len(jobs),
+				inputFile:  path.Join(cwd, file.Name()),
+				outputDir:  outputDirFor(file.Name()),
+				zipFile:    packagePathFor(file.Name(), packager),
+				subtitle:   selection[0],
+				audioTrack: selection[1],
+			})
 		}
 	}
-	wg.Wait()
+	workers := 1
+	if AppConfig.UseMulti {
+		workers = AppConfig.Workers()
+	}
+
+	done := startWork()
+	results := runTranscodes(ctx, jobs, workers, func(job transcodeJob) error {
+		fileBar := p.AddSpinner(1,
+			mpb.PrependDecorators(
+				decor.Name(fmt.Sprintf("Processing %s: ", filepath.Base(job.inputFile))),
+			),
+		)
+		defer fileBar.Increment()
+		return processMediaFile(ctx, job.inputFile, job.outputDir, job.zipFile, job.subtitle, job.audioTrack, profile, packager)
+	})
 	p.Shutdown()
+	done()
+
+	// Results come back in the order of the folder, however the workers finished
+	failedTranscodes.total = len(jobs)
+	for _, result := range results {
+		if result.err == nil {
+			zipFiles = append(zipFiles, result.job.zipFile)
+		} else if ctx.Err() == nil {
+			failedTranscodes.add(result.job.inputFile, result.err)
+		}
+	}
+	if ctx.Err() != nil {
+		fmt.Println("Interrupted, run FCLI in this folder again to carry on")
+		return nil, false
+	}
+	if len(zipFiles) == 0 {
+		fmt.Println("Nothing to upload")
+		return nil, false
+	}
 
-	for i, zipFile := range zipFiles {
+	// Resumed zips were named when they were made
+	for i, zipFile := range zipFiles[resumed:] {
+		i += resumed
 		newName := ConfirmOrEditZipName(r, zipFile)
 		if newName != zipFile {
 			err := os.Rename(zipFile, newName)
 			if err != nil {
 				log.Printf("Error renaming file %s to %s: %v", zipFile, newName, err)
 			} else {
+				moveSourceEntry(zipFile, newName)
+				pipeline.Update(pipeline.KeyForZip(zipFile), func(f *FileState) {
+					f.Zip = newName
+				})
 				zipFiles[i] = newName
 			}
 		}
@@ -153,13 +216,295 @@ func HandleTranscoding(r *bufio.Reader) ([]string, bool) {
 	return zipFiles, true
 }
 
-func TranscodeToHLSWithSubtitle(inputFile, outputDir, subtitle, audioTrack string) error {
-	encoder := getAvailableEncoder()
+// outputDirFor is where the HLS output of a media file is built
+func outputDirFor(fileName string) string {
+	stem := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	if AppConfig.TempDir != "" {
+		return filepath.Join(AppConfig.TempDir, stem)
+	}
+	return filepath.Join(cwd, stem)
+}
+
+// packagePathFor is where the package of a media file is written, the output
+// directory itself when it is uploaded without packaging
+func packagePathFor(fileName string, packager Packager) string {
+	if packager == nil {
+		return outputDirFor(fileName)
+	}
+	stem := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	return filepath.Join(cwd, stem) + packager.Extension()
+}
 
-	// Construct log file name based on input file name
-	inputFileName := filepath.Base(inputFile)
-	logFileName := fmt.Sprintf("%s-ffmpeg.log", strings.TrimSuffix(inputFileName, filepath.Ext(inputFileName)))
-	ffmpegLog, err := os.Create(filepath.Join("./", logFileName)) // Log file in current directory
+// processMediaFile transcodes a single file into outputDir, adds the artwork,
+// seek previews and chapters and packages the result into zipFileName. The
+// output directory is removed afterwards, unless it is uploaded as is. When
+// transcoding fails nothing is packaged.
+func processMediaFile(ctx context.Context, inputFile, outputDir, zipFileName, subtitle, audioTrack string, profile EncodeProfile, packager Packager) error {
+	entry := lookupSourceEntry(zipFileName)
+	record := HistoryEntry{
+		Status:        statusTranscoded,
+		Source:        inputFile,
+		Fingerprint:   entry.Fingerprint,
+		Subtitle:      subtitle,
+		AudioTrack:    audioTrack,
+		EncodeProfile: AppConfig.EncodeProfile,
+		Zip:           zipFileName,
+		Title:         entry.Title,
+		Series:        entry.Series,
+		Season:        entry.Season,
+		Episode:       entry.Episode,
+		Year:          entry.Year,
+	}
+	defer func() {
+		if err := RecordHistory(record); err != nil {
+			log.Printf("Error recording history for %s: %v", filepath.Base(inputFile), err)
+		}
+	}()
+	// When interrupted the unfinished output is thrown away, the state file
+	// keeps the file at its last finished stage
+	interrupted := func() bool {
+		if ctx.Err() == nil {
+			return false
+		}
+		record.Status = statusInterrupted
+		record.Error = ""
+		if err := os.RemoveAll(outputDir); err != nil {
+			log.Printf("Error deleting directory %s: %v", outputDir, err)
+		}
+		return true
+	}
+
+	// A failed file is tried again from the start on the next run, it isn't
+	// resumed like an interrupted one
+	// ffmpeg errors carry the whole log, the history only gets the first line
+	// and where the log is
+	failed := func(message string) {
+		record.Status = statusTranscodeFailed
+		record.Error = message
+		pipeline.Update(filepath.Base(inputFile), func(f *FileState) {
+			f.Stage = stageFailed
+		})
+	}
+
+	err := os.MkdirAll(outputDir, os.ModePerm)
+	if err != nil {
+		log.Printf("Error creating directory %s: %v", outputDir, err)
+		failed(firstLine(err))
+		return err
+	}
+
+	err = TranscodeToHLSWithSubtitle(ctx, inputFile, outputDir, subtitle, audioTrack, profile)
+	fallbackName := AppConfig.FallbackProfile
+	if err != nil && ctx.Err() == nil && fallbackName != "" && fallbackName != AppConfig.EncodeProfile {
+		if fallback, ok := AppConfig.EncodeProfiles[fallbackName]; !ok {
+			log.Printf("Unknown fallback profile %s", fallbackName)
+		} else {
+			log.Printf("Error transcoding file %s, trying again with the %s profile: %v", filepath.Base(inputFile), fallbackName, firstLine(err))
+			// Start from an empty directory so no segments of the failed attempt remain
+			os.RemoveAll(outputDir)
+			if err = os.MkdirAll(outputDir, os.ModePerm); err == nil {
+				record.EncodeProfile = fallbackName
+				err = TranscodeToHLSWithSubtitle(ctx, inputFile, outputDir, subtitle, audioTrack, fallback)
+			}
+		}
+	}
+	if interrupted() {
+		return ctx.Err()
+	}
+	if err != nil {
+		log.Printf("Error transcoding file %s: %v", filepath.Base(inputFile), err)
+		failed(fmt.Sprintf("%s (log: %s)", firstLine(err), ffmpegLogPath(inputFile)))
+		if err := os.RemoveAll(outputDir); err != nil {
+			log.Printf("Error deleting directory %s: %v", outputDir, err)
+		}
+		return err
+	}
+	pipeline.Update(filepath.Base(inputFile), func(f *FileState) {
+		f.Stage = stageTranscoded
+	})
+
+	if entry.Resolution != "" {
+		err = GenerateArtwork(ctx, inputFile, outputDir, entry.Runtime)
+		if err != nil {
+			log.Printf("Error generating artwork for %s: %v", filepath.Base(inputFile), err)
+		} else {
+			entry.Poster = posterFileName
+			entry.Thumbnail = thumbnailFileName
+			recordSourceEntry(zipFileName, entry)
+		}
+	}
+	if AppConfig.UseTrickplay && entry.Resolution != "" {
+		err = GenerateTrickplay(ctx, inputFile, outputDir, entry.Runtime, entry.Resolution)
+		if err != nil {
+			log.Printf("Error generating seek previews for %s: %v", filepath.Base(inputFile), err)
+		} else {
+			entry.Trickplay = trickplayFileName
+			recordSourceEntry(zipFileName, entry)
+		}
+	}
+	if interrupted() {
+		return ctx.Err()
+	}
+	hasChapters, err := GenerateChapters(inputFile, outputDir)
+	if err != nil {
+		log.Printf("Error extracting chapters from %s: %v", filepath.Base(inputFile), err)
+	} else if hasChapters {
+		entry.Chapters = chaptersFileName
+		recordSourceEntry(zipFileName, entry)
+	}
+
+	if packager == nil {
+		if interrupted() {
+			return ctx.Err()
+		}
+		pipeline.Update(filepath.Base(inputFile), func(f *FileState) {
+			f.Stage = stageZipped
+			f.Zip = outputDir
+		})
+		return nil
+	}
+
+	zipErr := PackageDirectory(ctx, packager, outputDir, zipFileName)
+	if interrupted() {
+		return ctx.Err()
+	}
+	if zipErr != nil {
+		log.Printf("Error packaging directory %s: %v", outputDir, zipErr)
+		failed(firstLine(zipErr))
+	} else {
+		pipeline.Update(filepath.Base(inputFile), func(f *FileState) {
+			f.Stage = stageZipped
+			f.Zip = zipFileName
+		})
+	}
+
+	err = os.RemoveAll(outputDir)
+	if err != nil {
+		log.Printf("Error deleting directory %s: %v", outputDir, err)
+	}
+	return zipErr
+}
+
+// ffmpegLogPath is the log of transcoding a file, kept in the current directory
+func ffmpegLogPath(inputFile string) string {
+	name := filepath.Base(inputFile)
+	logFileName := fmt.Sprintf("%s-ffmpeg.log", strings.TrimSuffix(name, filepath.Ext(name)))
+	if abs, err := filepath.Abs(logFileName); err == nil {
+		return abs
+	}
+	return logFileName
+}
+
+// transcodeJob is one selected media file and where its output goes
+type transcodeJob struct {
+	index      int // position among the selected files
+	inputFile  string
+	outputDir  string
+	zipFile    string
+	subtitle   string
+	audioTrack string
+}
+
+type transcodeResult struct {
+	job transcodeJob
+	err error
+}
+
+// runTranscodes runs process for the jobs on workers goroutines and collects
+// what they return on a channel. Only this goroutine puts the results
+// together, the state file, source metadata and history processMediaFile
+// writes to have locks of their own. Results are returned in the order of
+// jobs whatever order they finish in. Jobs not yet started when ctx is
+// cancelled are left out.
+func runTranscodes(ctx context.Context, jobs []transcodeJob, workers int, process func(job transcodeJob) error) []transcodeResult {
+	queue := make(chan transcodeJob)
+	results := make(chan transcodeResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				// The queue may have handed this over just as ctx was cancelled
+				if ctx.Err() != nil {
+					continue
+				}
+				results <- transcodeResult{job: job, err: process(job)}
+			}
+		}()
+	}
+	go func() {
+		defer close(queue)
+		for _, job := range jobs {
+			// Checked first, select picks at random when both are ready
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case queue <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	collected := make([]*transcodeResult, len(jobs))
+	for result := range results {
+		collected[result.job.index] = &result
+	}
+	var ordered []transcodeResult
+	for _, result := range collected {
+		if result != nil {
+			ordered = append(ordered, *result)
+		}
+	}
+	return ordered
+}
+
+// transcodeFailures collects the files that could not be transcoded for the summary
+type transcodeFailures struct {
+	total int // files that were transcoded
+	files []string
+	errs  []error
+}
+
+// failedTranscodes are the failures of this run, printed once the upload is
+// done so the summary isn't scrolled away
+var failedTranscodes transcodeFailures
+
+func (f *transcodeFailures) add(inputFile string, err error) {
+	f.files = append(f.files, inputFile)
+	f.errs = append(f.errs, err)
+}
+
+// print shows how many of the files failed and why
+func (f *transcodeFailures) print() {
+	if len(f.files) == 0 {
+		return
+	}
+	fmt.Printf("%d of %d files could not be transcoded and were not uploaded:\n", len(f.files), f.total)
+	for i, file := range f.files {
+		fmt.Printf("\t%s: %s\n\t\tlog: %s\n", filepath.Base(file), firstLine(f.errs[i]), ffmpegLogPath(file))
+	}
+	if AppConfig.FallbackProfile == "" {
+		fmt.Println("Set fallbackProfile (e.g. fcli config set fallbackProfile software) to retry failed files with another encode profile")
+	}
+}
+
+func firstLine(err error) string {
+	line, _, _ := strings.Cut(err.Error(), "\n")
+	return line
+}
+
+func TranscodeToHLSWithSubtitle(ctx context.Context, inputFile, outputDir, subtitle, audioTrack string, profile EncodeProfile) error {
+	encoder := getAvailableEncoder(profile)
+
+	ffmpegLog, err := os.Create(ffmpegLogPath(inputFile))
 	if err != nil {
 		return fmt.Errorf("error creating ffmpeg log file: %w", err)
 	}
@@ -175,7 +520,15 @@ func TranscodeToHLSWithSubtitle(inputFile, outputDir, subtitle, audioTrack strin
 		"-c:v", encoder,
 		"-c:a", "aac",
 		"-ac", "2",
-		"-crf", "23",
+	}
+	if profile.CRF > 0 {
+		baseArgs = append(baseArgs, "-crf", strconv.Itoa(profile.CRF))
+	}
+	if profile.Preset != "" {
+		baseArgs = append(baseArgs, "-preset", profile.Preset)
+	}
+	if profile.AudioBitrate != "" {
+		baseArgs = append(baseArgs, "-b:a", profile.AudioBitrate)
 	}
 
 	// Add audio track selection
@@ -204,12 +557,16 @@ func TranscodeToHLSWithSubtitle(inputFile, outputDir, subtitle, audioTrack strin
 	)
 
 	// Construct the FFmpeg command
-	cmd := exec.Command("ffmpeg", baseArgs...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", baseArgs...)
+	killProcessGroupOnCancel(cmd)
 	cmd.Stdout = ffmpegLog
 	cmd.Stderr = ffmpegLog
 
 	// Start and wait for the command
 	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		// Read and include log content in the error message
 		logContent, readErr := os.ReadFile(ffmpegLog.Name())
 		if readErr != nil {
@@ -354,7 +711,6 @@ func getAudioTracks(inputFile string) ([]string, error) {
 	cmd.Stdout = &combinedOutput
 	cmd.Stderr = &combinedOutput
 
-	// Construct log file name based on input file name
 	inputFileName := filepath.Base(inputFile)
 	logFileName := fmt.Sprintf("%s-audio-ffprobe.log", strings.TrimSuffix(inputFileName, filepath.Ext(inputFileName)))
 	logFilePath := filepath.Join(filepath.Dir(inputFile), logFileName)
@@ -385,8 +741,11 @@ func getAudioTracks(inputFile string) ([]string, error) {
 
 	return audioTracks, nil
 }
-func getAvailableEncoder() string {
-	if !UseHardwareAccel {
+func getAvailableEncoder(profile EncodeProfile) string {
+	if profile.Encoder != "" && profile.Encoder != "auto" {
+		return profile.Encoder
+	}
+	if !AppConfig.UseHardwareAccel {
 		// Default to CPU encoding
 		return "libx264"
 	}
@@ -412,48 +771,6 @@ func getAvailableEncoder() string {
 	return "libx264"
 }
 
-func ZipDirectory(source, target string) error {
-	zipfile, err := os.Create(target)
-	if err != nil {
-		return err
-	}
-	defer zipfile.Close()
-
-	archive := zip.NewWriter(zipfile)
-	defer archive.Close()
-
-	filepath.Walk(source, func(file string, fi os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if fi.IsDir() {
-			return nil
-		}
-
-		relPath, err := filepath.Rel(source, file)
-		if err != nil {
-			return err
-		}
-
-		zipFile, err := archive.Create(relPath)
-		if err != nil {
-			return err
-		}
-
-		fsFile, err := os.Open(file)
-		if err != nil {
-			return err
-		}
-		defer fsFile.Close()
-
-		_, err = io.Copy(zipFile, fsFile)
-		return err
-	})
-
-	return nil
-}
-
 func PrintHeader() string {
 	header := "" +
 		"\nSimple Commands: " +
@@ -542,17 +859,20 @@ func isMediaFile(fileName string) bool {
 
 func ConfirmOrEditZipName(reader *bufio.Reader, fullPath string) string {
 	// Extract the base name of the file
-	defaultName := filepath.Base(fullPath)
-	defaultName = strings.TrimSuffix(defaultName, filepath.Ext(defaultName))
+	defaultName := packageStem(fullPath)
 
-	fmt.Printf("Suggested zip file name: %s\n", defaultName)
+	fmt.Printf("Suggested name: %s\n", defaultName)
 	fmt.Print("Press Enter to confirm or type a new name: ")
 	input, _ := reader.ReadString('\n')
 	input = strings.TrimSpace(input)
 	if input == "" {
 		return fullPath // Return the original full path if no change
 	}
-	// Construct the new full path with the edited file name
+	// Construct the new full path with the edited file name, keeping the extension
+	ext, _ := packageExtension(fullPath)
+	if !strings.HasSuffix(input, ext) {
+		input += ext
+	}
 	newFullPath := filepath.Join(filepath.Dir(fullPath), input)
 	return newFullPath
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func testJobs(n int) []transcodeJob {
+	jobs := make([]transcodeJob, n)
+	for i := range jobs {
+		jobs[i] = transcodeJob{index: i, inputFile: string(rune('a' + i))}
+	}
+	return jobs
+}
+
+func TestRunTranscodesKeepsJobOrder(t *testing.T) {
+	jobs := testJobs(6)
+	// Every job waits for the one after it, so they finish last to first
+	finished := make([]chan struct{}, len(jobs)+1)
+	for i := range finished {
+		finished[i] = make(chan struct{})
+	}
+	close(finished[len(jobs)])
+	var mu sync.Mutex
+	var order []int
+
+	results := runTranscodes(context.Background(), jobs, len(jobs), func(job transcodeJob) error {
+		<-finished[job.index+1]
+		mu.Lock()
+		order = append(order, job.index)
+		mu.Unlock()
+		close(finished[job.index])
+		return nil
+	})
+
+	if order[0] != len(jobs)-1 {
+		t.Fatalf("jobs finished in order %v, expected last to first", order)
+	}
+	if len(results) != len(jobs) {
+		t.Fatalf("got %d results, expected %d", len(results), len(jobs))
+	}
+	for i, result := range results {
+		if result.job.index != i || result.err != nil {
+			t.Errorf("result %d is job %d with error %v", i, result.job.index, result.err)
+		}
+	}
+}
+
+func TestRunTranscodesKeepsFailures(t *testing.T) {
+	jobs := testJobs(10)
+	failed := errors.New("transcode failed")
+
+	results := runTranscodes(context.Background(), jobs, 3, func(job transcodeJob) error {
+		if job.index%3 == 0 {
+			return failed
+		}
+		return nil
+	})
+
+	if len(results) != len(jobs) {
+		t.Fatalf("got %d results, expected %d", len(results), len(jobs))
+	}
+	for i, result := range results {
+		if want := i%3 == 0; (result.err != nil) != want || result.job.index != i {
+			t.Errorf("result %d is job %d with error %v", i, result.job.index, result.err)
+		}
+	}
+}
+
+func TestRunTranscodesLimitsWorkers(t *testing.T) {
+	var running, peak atomic.Int32
+	runTranscodes(context.Background(), testJobs(20), 4, func(job transcodeJob) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		return nil
+	})
+	if peak.Load() > 4 {
+		t.Errorf("%d jobs ran at once with 4 workers", peak.Load())
+	}
+}
+
+func TestRunTranscodesDropsJobsAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var processed atomic.Int32
+
+	results := runTranscodes(ctx, testJobs(5), 1, func(job transcodeJob) error {
+		processed.Add(1)
+		if job.index == 1 {
+			cancel()
+			return ctx.Err()
+		}
+		return nil
+	})
+
+	if processed.Load() != 2 {
+		t.Errorf("processed %d jobs, expected the 2 started before the cancel", processed.Load())
+	}
+	if len(results) != 2 || results[0].job.index != 0 || results[1].job.index != 1 {
+		t.Fatalf("got results %v, expected jobs 0 and 1", results)
+	}
+	if !errors.Is(results[1].err, context.Canceled) {
+		t.Errorf("cancelled job returned %v", results[1].err)
+	}
+}
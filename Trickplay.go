@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const trickplayFileName = "thumbnails.vtt"
+const trickplayInterval = 10 // seconds between preview frames
+const trickplayWidth = 160
+const trickplayColumns = 10
+const trickplayRows = 10
+
+// GenerateTrickplay writes sprite sheets of preview frames and a WebVTT file
+// pointing into them, so players can show a preview while seeking.
+func GenerateTrickplay(ctx context.Context, inputFile, outputDir string, runtime int, resolution string) error {
+	if runtime <= 0 {
+		return fmt.Errorf("unknown duration")
+	}
+	tileHeight, err := trickplayTileHeight(resolution)
+	if err != nil {
+		return err
+	}
+
+	filter := fmt.Sprintf("fps=1/%d,scale=%d:%d,tile=%dx%d", trickplayInterval, trickplayWidth, tileHeight, trickplayColumns, trickplayRows)
+	err = runFFmpeg(ctx, "-i", inputFile, "-an", "-sn", "-vf", filter, "-q:v", "5",
+		"-start_number", "0", filepath.Join(outputDir, "sprite_%03d.jpg"))
+	if err != nil {
+		return err
+	}
+
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n\n")
+	perSheet := trickplayColumns * trickplayRows
+	for i := 0; i*trickplayInterval < runtime; i++ {
+		start := i * trickplayInterval
+		end := min(start+trickplayInterval, runtime)
+		sheet := i / perSheet
+		x := (i % perSheet % trickplayColumns) * trickplayWidth
+		y := (i % perSheet / trickplayColumns) * tileHeight
+		fmt.Fprintf(&vtt, "%s --> %s\nsprite_%03d.jpg#xywh=%d,%d,%d,%d\n\n",
+			vttTimestamp(float64(start)), vttTimestamp(float64(end)), sheet, x, y, trickplayWidth, tileHeight)
+	}
+
+	return os.WriteFile(filepath.Join(outputDir, trickplayFileName), []byte(vtt.String()), 0644)
+}
+
+// trickplayTileHeight keeps the aspect ratio of the source, rounded to an even number
+func trickplayTileHeight(resolution string) (int, error) {
+	w, h, ok := strings.Cut(resolution, "x")
+	width, errW := strconv.Atoi(w)
+	height, errH := strconv.Atoi(h)
+	if !ok || errW != nil || errH != nil || width == 0 {
+		return 0, fmt.Errorf("invalid resolution %q", resolution)
+	}
+	tileHeight := trickplayWidth * height / width
+	return tileHeight + tileHeight%2, nil
+}
+
+func vttTimestamp(seconds float64) string {
+	ms := int(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
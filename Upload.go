@@ -3,140 +3,81 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/vbauerster/mpb/v8"
 	"github.com/vbauerster/mpb/v8/decor"
 	"io"
+	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-func HandleUpload(r *bufio.Reader, zipFiles []string) bool {
+func HandleUpload(ctx context.Context, r *bufio.Reader, zipFiles []string) bool {
 	GenerateMetaData(r)
+	ConfirmFileMetaData(r, zipFiles)
+	var uploads []string
+	for _, zipFile := range zipFiles {
+		if !SkipDuplicate(r, filepath.Base(zipFile), AttachMetaData(zipFile), ArchiveChecksum(zipFile)) {
+			uploads = append(uploads, zipFile)
+		}
+	}
+	zipFiles = uploads
+	if len(zipFiles) == 0 {
+		fmt.Println("Nothing left to upload")
+		return true
+	}
 	fmt.Println("Initiating swarm upload")
 
-	const chunkSize = 15 * 1024 * 1024 // 15 MB
+	transport, err := NewTransport(CurrentProfile)
+	if err != nil {
+		fmt.Println("Error setting up the connection:", err)
+		return false
+	}
 
-	// Create a new progress bar container
-	p := mpb.New()
+	windows, err := ParseUploadWindows(AppConfig.UploadWindow)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+
+	// One client for every chunk so connections are reused
+	u := &uploader{
+		ctx:      ctx,
+		r:        r,
+		client:   &http.Client{Transport: transport},
+		windows:  windows,
+		limiter:  NewRateLimiter(AppConfig.Limit),
+		progress: mpb.New(),
+	}
+	u.chunkSize = int64(AppConfig.ChunkSize)
+	if u.chunkSize <= 0 {
+		u.chunkSize = int64(DefaultConfig().ChunkSize)
+	}
+	p := u.progress
 
 	var wg sync.WaitGroup
 	errorChan := make(chan error, len(zipFiles))
+	done := startWork()
+	defer done()
 
 	for _, zipFile := range zipFiles {
 		wg.Add(1)
 		go func(zipFile string) {
 			defer wg.Done()
-
-			file, err := os.Open(zipFile)
-			if err != nil {
-				errorChan <- fmt.Errorf("error opening file %s: %v", zipFile, err)
-				return
-			}
-			defer file.Close()
-
-			fileInfo, err := file.Stat()
-			if err != nil {
-				errorChan <- fmt.Errorf("error getting file info: %v", err)
-				return
+			if err := u.upload(zipFile); err != nil {
+				errorChan <- err
 			}
-			fileSize := fileInfo.Size()
-
-			totalChunks := (fileSize + chunkSize - 1) / chunkSize // Calculate total number of chunks
-
-			// Create a progress bar for the file
-			fileBar := p.AddBar(fileSize,
-				mpb.PrependDecorators(
-					decor.Name(fmt.Sprintf("Uploading %s: ", filepath.Base(zipFile))),
-					decor.CountersKibiByte("% .2f / % .2f"),
-				),
-				mpb.AppendDecorators(decor.Percentage()),
-			)
-
-			metadata := AttachMetaData(filepath.Base(zipFile))
-			metadataJSON, err := json.Marshal(metadata)
-			if err != nil {
-				errorChan <- fmt.Errorf("error marshalling metadata: %v", err)
-				return
-			}
-
-			for offset := int64(0); offset < fileSize; offset += chunkSize {
-				chunk := make([]byte, chunkSize)
-				n, err := file.ReadAt(chunk, offset)
-				if err != nil && err != io.EOF {
-					errorChan <- fmt.Errorf("error reading file chunk: %v", err)
-					return
-				}
-
-				var requestBody bytes.Buffer
-				writer := multipart.NewWriter(&requestBody)
-
-				part, err := writer.CreateFormFile("file", filepath.Base(zipFile))
-				if err != nil {
-					errorChan <- fmt.Errorf("error creating form file: %v", err)
-					return
-				}
-
-				_, err = part.Write(chunk[:n])
-				if err != nil {
-					errorChan <- fmt.Errorf("error writing chunk to form: %v", err)
-					return
-				}
-
-				err = writer.WriteField("metadata", string(metadataJSON))
-				if err != nil {
-					errorChan <- fmt.Errorf("error writing metadata field: %v", err)
-					return
-				}
-
-				err = writer.WriteField("totalChunks", fmt.Sprintf("%d", totalChunks))
-				if err != nil {
-					errorChan <- fmt.Errorf("error writing totalChunks field: %v", err)
-					return
-				}
-
-				err = writer.WriteField("chunkIndex", fmt.Sprintf("%d", offset/chunkSize))
-				if err != nil {
-					errorChan <- fmt.Errorf("error writing chunkIndex field: %v", err)
-					return
-				}
-
-				writer.Close()
-
-				requestURL := fmt.Sprintf("%v/upload/", API_BASE_URL)
-				req, err := http.NewRequest("POST", requestURL, &requestBody)
-				if err != nil {
-					errorChan <- fmt.Errorf("error creating request: %v", err)
-					return
-				}
-
-				req.Header.Set("Content-Type", writer.FormDataContentType())
-				req.Header.Set("Authorization", "Bearer "+Token)
-
-				client := &http.Client{
-					Timeout: time.Minute * 30,
-				}
-				resp, err := client.Do(req)
-				if err != nil {
-					errorChan <- fmt.Errorf("error sending request: %v", err)
-					return
-				}
-				defer resp.Body.Close()
-
-				if resp.StatusCode != http.StatusOK {
-					errorChan <- fmt.Errorf("upload failed for file %s: %s", zipFile, resp.Status)
-					return
-				}
-
-				fileBar.IncrBy(n)
-			}
-
-			fmt.Printf("Successfully uploaded file %s\n", zipFile)
 		}(zipFile)
 	}
 
@@ -155,3 +96,508 @@ func HandleUpload(r *bufio.Reader, zipFiles []string) bool {
 
 	return true
 }
+
+// uploader holds what the uploads of one run share
+type uploader struct {
+	ctx       context.Context
+	r         *bufio.Reader
+	client    *http.Client
+	windows   []UploadWindow
+	limiter   *RateLimiter
+	chunkSize int64
+	progress  *mpb.Progress
+
+	// noDirectoryUpload is set once the server turned down a directory upload
+	noDirectoryUpload atomic.Bool
+}
+
+// upload sends one archive, or output directory, and records the outcome in the history
+func (u *uploader) upload(zipFile string) error {
+	record := HistoryEntry{
+		Status: statusUploadFailed,
+		Source: findSourceFor(zipFile),
+		Zip:    zipFile,
+		URL:    API_BASE_URL,
+	}
+	defer func() {
+		if err := RecordHistory(record); err != nil {
+			log.Printf("Error recording history for %s: %v", zipFile, err)
+		}
+	}()
+
+	info, err := os.Stat(zipFile)
+	if err == nil && info.IsDir() {
+		err = u.uploadDirectory(zipFile, &record)
+	} else if err == nil {
+		err = u.uploadArchive(zipFile, &record)
+	}
+	if err != nil {
+		// Chunks sent so far are in the state file, the next run carries on from there
+		if u.ctx.Err() != nil {
+			record.Status = statusInterrupted
+			err = u.ctx.Err()
+		}
+		record.Error = err.Error()
+		return err
+	}
+	record.Status = statusUploaded
+	return nil
+}
+
+// recordMetadata adds the metadata sent with a file to its history entry
+func recordMetadata(record *HistoryEntry, metadata MediaIndexEntry) {
+	record.Title = metadata.Title
+	record.Directory = metadata.Directory
+	record.Series = metadata.Series
+	record.Season = metadata.Season
+	record.Episode = metadata.Episode
+	record.Year = metadata.Year
+	record.Fingerprint = metadata.Fingerprint
+}
+
+// uploadArchive sends an archive in chunks of chunkSize
+func (u *uploader) uploadArchive(zipFile string, record *HistoryEntry) error {
+	file, err := os.Open(zipFile)
+	if err != nil {
+		return fmt.Errorf("error opening file %s: %v", zipFile, err)
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("error getting file info: %v", err)
+	}
+	fileSize := fileInfo.Size()
+	record.Size = fileSize
+	chunkSize := u.chunkSize
+
+	totalChunks := (fileSize + chunkSize - 1) / chunkSize // Calculate total number of chunks
+
+	// Create a progress bar for the file
+	fileBar := u.addBar(zipFile, fileSize)
+
+	sums, err := ChecksumFile(file, fileSize, chunkSize)
+	if err != nil {
+		return fmt.Errorf("error hashing file %s: %v", zipFile, err)
+	}
+
+	record.SHA256 = sums.File
+	record.ChunkSize = chunkSize
+	record.Chunks = sums.Chunks
+
+	metadata := AttachMetaData(zipFile)
+	recordMetadata(record, metadata)
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("error marshalling metadata: %v", err)
+	}
+
+	// Carry on after the chunks an interrupted run got through, as
+	// long as it is the same zip cut into the same chunks
+	stateKey := pipeline.KeyForZip(zipFile)
+	start := int64(0)
+	if state, ok := pipeline.Get(stateKey); ok && state.ZipSHA256 == sums.File &&
+		state.ChunkSize == chunkSize && state.URL == API_BASE_URL && state.UploadedChunks < totalChunks {
+		start = state.UploadedChunks * chunkSize
+		if start > 0 {
+			fmt.Printf("Resuming upload of %s at chunk %d of %d\n", filepath.Base(zipFile), state.UploadedChunks+1, totalChunks)
+		}
+	}
+	fileBar.SetCurrent(start)
+
+	for offset := start; offset < fileSize; offset += chunkSize {
+		n := min(chunkSize, fileSize-offset)
+		fields := [][2]string{
+			{"metadata", string(metadataJSON)},
+			{"totalChunks", fmt.Sprintf("%d", totalChunks)},
+			{"chunkIndex", fmt.Sprintf("%d", offset/chunkSize)},
+			{"chunkSha256", sums.Chunks[offset/chunkSize]},
+			{"fileSha256", sums.File},
+		}
+		body, err := newChunkBody(file, offset, n, filepath.Base(zipFile), fields)
+		if err != nil {
+			return fmt.Errorf("error creating form: %v", err)
+		}
+		body.Endpoint = "upload/"
+		body.ChunkSum = sums.Chunks[offset/chunkSize]
+		body.FileSum = sums.File
+		body.limiter = u.limiter
+		body.progress = fileBar
+
+		if err := WaitForUploadWindow(u.ctx, u.windows); err != nil {
+			return err
+		}
+
+		record.Response, err = postChunk(u.ctx, u.r, u.client, body)
+		if err != nil {
+			return fmt.Errorf("upload failed for file %s: %v", zipFile, err)
+		}
+		pipeline.Update(stateKey, func(f *FileState) {
+			if f.Stage == "" {
+				f.Stage = stageZipped
+			}
+			f.Zip = zipFile
+			f.ZipSHA256 = sums.File
+			f.ChunkSize = chunkSize
+			f.URL = API_BASE_URL
+			f.UploadedChunks = offset/chunkSize + 1
+		})
+	}
+
+	fmt.Printf("Successfully uploaded file %s\n", zipFile)
+	pipeline.Update(stateKey, func(f *FileState) {
+		f.Stage = stageUploaded
+	})
+	return nil
+}
+
+// uploadDirectory sends the HLS output file by file to upload/directory/, so
+// no archive has to be written. Servers without that endpoint get a zip of
+// the directory instead. The directory is removed once uploaded.
+func (u *uploader) uploadDirectory(dir string, record *HistoryEntry) error {
+	if u.noDirectoryUpload.Load() {
+		return u.uploadZipOf(dir, record)
+	}
+
+	names, err := listFiles(dir)
+	if err != nil {
+		return fmt.Errorf("error listing %s: %v", dir, err)
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("directory %s is empty", dir)
+	}
+
+	// The listing with the checksum of every file identifies the upload, for
+	// the server to put the files together and for resuming
+	sums := make([]string, len(names))
+	sizes := make([]int64, len(names))
+	listing := sha256.New()
+	var total int64
+	for i, name := range names {
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return fmt.Errorf("error opening file %s: %v", name, err)
+		}
+		info, err := f.Stat()
+		if err == nil {
+			sizes[i] = info.Size()
+			var fileSums FileChecksums
+			fileSums, err = ChecksumFile(f, sizes[i], max(sizes[i], 1))
+			sums[i] = fileSums.File
+		}
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error hashing file %s: %v", name, err)
+		}
+		fmt.Fprintf(listing, "%s\x00%s\n", name, sums[i])
+		total += sizes[i]
+	}
+	listingSum := hexSum(listing)
+	record.Size = total
+	record.SHA256 = listingSum
+	record.Chunks = sums
+
+	metadata := AttachMetaData(dir)
+	recordMetadata(record, metadata)
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("error marshalling metadata: %v", err)
+	}
+
+	// Carry on after the files an interrupted run got through
+	stateKey := pipeline.KeyForZip(dir)
+	start := 0
+	if state, ok := pipeline.Get(stateKey); ok && state.ZipSHA256 == listingSum &&
+		state.URL == API_BASE_URL && state.UploadedChunks < int64(len(names)) {
+		start = int(state.UploadedChunks)
+		if start > 0 {
+			fmt.Printf("Resuming upload of %s at file %d of %d\n", filepath.Base(dir), start+1, len(names))
+		}
+	}
+	fileBar := u.addBar(dir, total)
+	var done int64
+	for _, size := range sizes[:start] {
+		done += size
+	}
+	fileBar.SetCurrent(done)
+
+	for i := start; i < len(names); i++ {
+		err := u.uploadDirectoryFile(dir, names[i], sums[i], sizes[i], done, fileBar, record, [][2]string{
+			{"metadata", string(metadataJSON)},
+			{"path", names[i]},
+			{"totalFiles", fmt.Sprintf("%d", len(names))},
+			{"fileIndex", fmt.Sprintf("%d", i)},
+			{"fileSha256", sums[i]},
+			{"directorySha256", listingSum},
+		})
+		var statusErr *StatusError
+		if i == start && errors.As(err, &statusErr) && statusErr.Unsupported() {
+			u.noDirectoryUpload.Store(true)
+			fileBar.Abort(true)
+			fmt.Printf("The server doesn't take directory uploads, zipping %s instead\n", filepath.Base(dir))
+			return u.uploadZipOf(dir, record)
+		}
+		if err != nil {
+			return fmt.Errorf("upload failed for %s: %v", path.Join(filepath.Base(dir), names[i]), err)
+		}
+		done += sizes[i]
+		pipeline.Update(stateKey, func(f *FileState) {
+			if f.Stage == "" {
+				f.Stage = stageZipped
+			}
+			f.Zip = dir
+			f.ZipSHA256 = listingSum
+			f.ChunkSize = 0
+			f.URL = API_BASE_URL
+			f.UploadedChunks = int64(i + 1)
+		})
+	}
+
+	fmt.Printf("Successfully uploaded directory %s\n", dir)
+	pipeline.Update(stateKey, func(f *FileState) {
+		f.Stage = stageUploaded
+	})
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("Error deleting directory %s: %v", dir, err)
+	}
+	return nil
+}
+
+func (u *uploader) uploadDirectoryFile(dir, name, sum string, size, progressBase int64, bar *mpb.Bar, record *HistoryEntry, fields [][2]string) error {
+	file, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	body, err := newChunkBody(file, 0, size, path.Base(name), fields)
+	if err != nil {
+		return fmt.Errorf("error creating form: %v", err)
+	}
+	body.Endpoint = "upload/directory/"
+	body.ChunkSum = sum
+	body.FileSum = sum
+	body.limiter = u.limiter
+	body.progress = bar
+	body.progressBase = progressBase
+
+	if err := WaitForUploadWindow(u.ctx, u.windows); err != nil {
+		return err
+	}
+	record.Response, err = postChunk(u.ctx, u.r, u.client, body)
+	return err
+}
+
+// uploadZipOf zips an output directory next to its source and uploads that
+// instead, for servers that only take archives
+func (u *uploader) uploadZipOf(dir string, record *HistoryEntry) error {
+	zipFile := filepath.Join(cwd, filepath.Base(dir)) + zipPackager{}.Extension()
+	if err := PackageDirectory(u.ctx, zipPackager{}, dir, zipFile); err != nil {
+		return fmt.Errorf("error zipping directory %s: %v", dir, err)
+	}
+	moveSourceEntry(dir, zipFile)
+	pipeline.Update(pipeline.KeyForZip(dir), func(f *FileState) {
+		f.Stage = stageZipped
+		f.Zip = zipFile
+		f.UploadedChunks = 0
+	})
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("Error deleting directory %s: %v", dir, err)
+	}
+	record.Zip = zipFile
+	return u.uploadArchive(zipFile, record)
+}
+
+func (u *uploader) addBar(name string, size int64) *mpb.Bar {
+	return u.progress.AddBar(size,
+		mpb.PrependDecorators(
+			decor.Name(fmt.Sprintf("Uploading %s: ", filepath.Base(name))),
+			decor.CountersKibiByte("% .2f / % .2f"),
+		),
+		mpb.AppendDecorators(
+			decor.Percentage(),
+			decor.Name(" "),
+			decor.EwmaSpeed(decor.SizeB1024(0), "% .2f", 30),
+			decor.Name(" ETA "),
+			decor.EwmaETA(decor.ET_STYLE_GO, 30),
+		),
+	)
+}
+
+// StatusError is a request the server answered with something other than 200 OK
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, http.StatusText(e.Code))
+}
+
+// Unsupported reports whether the server doesn't offer the endpoint at all
+func (e *StatusError) Unsupported() bool {
+	return e.Code == http.StatusNotFound || e.Code == http.StatusMethodNotAllowed || e.Code == http.StatusNotImplemented
+}
+
+const maxChecksumRetries = 2
+
+// maxReplyLength is how much of the server's answer is kept for the history
+const maxReplyLength = 2048
+
+// postChunk sends one chunk request and returns the server's answer. When the
+// cached session was rejected it logs in again and retries once, when the
+// server reports a different checksum the chunk is sent again up to
+// maxChecksumRetries times.
+func postChunk(ctx context.Context, r *bufio.Reader, client *http.Client, body *chunkBody) (string, error) {
+	token := CurrentToken()
+	refreshed := false
+	corrupted := 0
+	for {
+		status, reply, err := sendChunkRequest(ctx, client, body, token)
+		var checksumErr *ChecksumError
+		if errors.As(err, &checksumErr) && corrupted < maxChecksumRetries {
+			corrupted++
+			log.Printf("Resending chunk: %v", err)
+			continue
+		}
+		if err != nil {
+			return reply, err
+		}
+
+		// The cached session may have expired on the server, log in again once
+		if status == http.StatusUnauthorized && !refreshed {
+			var ok bool
+			token, ok = RefreshSession(r, token)
+			if !ok {
+				return reply, fmt.Errorf("could not log in again")
+			}
+			refreshed = true
+			continue
+		}
+
+		if status != http.StatusOK {
+			return reply, &StatusError{Code: status}
+		}
+		return reply, nil
+	}
+}
+
+func sendChunkRequest(ctx context.Context, client *http.Client, body *chunkBody, token string) (int, string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// Like loginTimeout, 0 means no timeout
+	timeout := time.Duration(AppConfig.UploadTimeout)
+	var timer *requestTimer
+	if timeout > 0 {
+		timer = newRequestTimer(timeout, cancel)
+	}
+	defer timer.stop()
+
+	requestURL := fmt.Sprintf("%v/%s", API_BASE_URL, body.Endpoint)
+	req, err := http.NewRequestWithContext(ctx, "POST", requestURL, body.Reader(ctx, timer))
+	if err != nil {
+		return 0, "", fmt.Errorf("error creating request: %v", err)
+	}
+	// Lets the transport rewind the body if it has to resend the request
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(body.Reader(ctx, timer)), nil
+	}
+	req.ContentLength = body.Len()
+	req.Header.Set("Content-Type", body.ContentType)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Chunk-SHA256", body.ChunkSum)
+	req.Header.Set("X-File-SHA256", body.FileSum)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if timer.stop() {
+			return 0, "", fmt.Errorf("no answer within the upload timeout of %v", timeout)
+		}
+		return 0, "", fmt.Errorf("error sending request: %v", err)
+	}
+	// Read the rest of the body so the connection can be reused
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+
+	reply, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		if timer.stop() {
+			return 0, "", fmt.Errorf("no answer within the upload timeout of %v", timeout)
+		}
+		return 0, "", fmt.Errorf("error reading response: %v", err)
+	}
+	text := strings.TrimSpace(string(reply[:min(len(reply), maxReplyLength)]))
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, text, nil
+	}
+	return resp.StatusCode, text, verifyEchoedChecksums(resp.Header, reply, body.ChunkSum, body.FileSum)
+}
+
+// chunkBody is a multipart form with one chunk of a file. Only the small form
+// framing is kept in memory, the chunk itself is read straight from the file
+// while the request is sent, so memory use doesn't grow with the chunk size
+// or the number of files uploading at once.
+type chunkBody struct {
+	ContentType string
+	Endpoint    string // path below the API to post to, e.g. upload/
+	ChunkSum    string // SHA-256 of the chunk
+	FileSum     string // SHA-256 of the whole file
+	head        []byte // form fields before the file data
+	tail        []byte // form fields after it and the closing boundary
+	file        io.ReaderAt
+	offset      int64
+	size        int64
+	limiter     *RateLimiter
+	progress    *mpb.Bar
+	// progressBase is where the body starts on the progress bar, past the
+	// files of a directory sent before it
+	progressBase int64
+}
+
+func newChunkBody(file io.ReaderAt, offset, size int64, fileName string, fields [][2]string) (*chunkBody, error) {
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	if _, err := writer.CreateFormFile("file", fileName); err != nil {
+		return nil, err
+	}
+	head := bytes.Clone(form.Bytes())
+	form.Reset()
+
+	for _, field := range fields {
+		if err := writer.WriteField(field[0], field[1]); err != nil {
+			return nil, fmt.Errorf("error writing %s field: %v", field[0], err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return &chunkBody{
+		ContentType: writer.FormDataContentType(),
+		head:        head,
+		tail:        form.Bytes(),
+		file:        file,
+		offset:      offset,
+		size:        size,
+	}, nil
+}
+
+// Reader returns a new reader over the whole body, so a request can be retried
+func (b *chunkBody) Reader(ctx context.Context, timer *requestTimer) io.Reader {
+	if b.progress != nil {
+		// Bytes of an earlier attempt at this chunk don't count
+		b.progress.SetCurrent(b.progressBase + b.offset)
+	}
+	data := &throttledReader{
+		ctx:     ctx,
+		r:       io.NewSectionReader(b.file, b.offset, b.size),
+		limiter: b.limiter,
+		timer:   timer,
+		bar:     b.progress,
+		last:    time.Now(),
+	}
+	return io.MultiReader(bytes.NewReader(b.head), data, bytes.NewReader(b.tail))
+}
+
+func (b *chunkBody) Len() int64 {
+	return int64(len(b.head)) + b.size + int64(len(b.tail))
+}
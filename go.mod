@@ -5,6 +5,7 @@ go 1.23.4
 require (
 	github.com/schollz/progressbar/v3 v3.17.1
 	github.com/vbauerster/mpb/v8 v8.8.3
+	golang.org/x/crypto v0.31.0
 	golang.org/x/term v0.27.0
 )
 